@@ -0,0 +1,163 @@
+package hpke
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+//////////
+// CCM
+
+// ccm implements the CCM mode of NIST SP 800-38C over a 128-bit block cipher,
+// with a 12-byte nonce and a 16-byte tag. A 12-byte nonce leaves three bytes
+// for the message length, so messages are limited to 2^24 - 1 bytes.
+type ccm struct {
+	block cipher.Block
+}
+
+const (
+	ccmNonceSize = 12
+	ccmTagSize   = 16
+	ccmMaxLength = 1<<(8*(15-ccmNonceSize)) - 1
+)
+
+var errCCMOpen = errors.New("ccm: message authentication failed")
+
+// newCCM returns the CCM mode of block, which must have a 128-bit block size.
+func newCCM(block cipher.Block) (cipher.AEAD, error) {
+	if block.BlockSize() != 16 {
+		return nil, errors.New("ccm: block size must be 16 bytes")
+	}
+	return &ccm{block: block}, nil
+}
+
+func (c *ccm) NonceSize() int {
+	return ccmNonceSize
+}
+
+func (c *ccm) Overhead() int {
+	return ccmTagSize
+}
+
+// maxMessageSize lets EncryptContext.Seal reject long messages before Seal
+// panics on them.
+func (c *ccm) maxMessageSize() int {
+	return ccmMaxLength
+}
+
+// counter returns the counter block A_i for the given nonce.
+func (c *ccm) counter(nonce []byte, i uint32) []byte {
+	ctr := make([]byte, 16)
+	ctr[0] = byte(15 - ccmNonceSize - 1)
+	copy(ctr[1:], nonce)
+	ctr[13] = byte(i >> 16)
+	ctr[14] = byte(i >> 8)
+	ctr[15] = byte(i)
+	return ctr
+}
+
+// mac returns the CBC-MAC of the formatted nonce, additional data and
+// plaintext, i.e., the tag before it is encrypted.
+func (c *ccm) mac(nonce, plaintext, additionalData []byte) []byte {
+	b0 := make([]byte, 16)
+	b0[0] = byte((ccmTagSize-2)/2<<3 | (15 - ccmNonceSize - 1))
+	if len(additionalData) > 0 {
+		b0[0] |= 1 << 6
+	}
+	copy(b0[1:], nonce)
+	b0[13] = byte(len(plaintext) >> 16)
+	b0[14] = byte(len(plaintext) >> 8)
+	b0[15] = byte(len(plaintext))
+
+	tag := make([]byte, 16)
+	c.block.Encrypt(tag, b0)
+
+	if len(additionalData) > 0 {
+		var encoded []byte
+		switch n := uint64(len(additionalData)); {
+		case n < 1<<16-1<<8:
+			encoded = binary.BigEndian.AppendUint16(nil, uint16(n))
+		case n <= math.MaxUint32:
+			encoded = binary.BigEndian.AppendUint32([]byte{0xff, 0xfe}, uint32(n))
+		default:
+			encoded = binary.BigEndian.AppendUint64([]byte{0xff, 0xff}, n)
+		}
+		c.cbcMAC(tag, append(encoded, additionalData...))
+	}
+
+	c.cbcMAC(tag, plaintext)
+	return tag
+}
+
+// cbcMAC absorbs data, zero-padded to a multiple of the block size, into tag.
+func (c *ccm) cbcMAC(tag, data []byte) {
+	for len(data) > 0 {
+		n := subtle.XORBytes(tag, tag, data)
+		c.block.Encrypt(tag, tag)
+		data = data[n:]
+	}
+}
+
+// Seal panics if the nonce has the wrong size or the plaintext is longer than
+// 2^24 - 1 bytes, as cipher.NewGCM does for its own limits.
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != ccmNonceSize {
+		panic("ccm: incorrect nonce length given to CCM")
+	}
+	if len(plaintext) > ccmMaxLength {
+		panic("ccm: message too large for CCM")
+	}
+
+	tag := c.mac(nonce, plaintext, additionalData)
+
+	s0 := make([]byte, 16)
+	c.block.Encrypt(s0, c.counter(nonce, 0))
+	subtle.XORBytes(tag, tag, s0)
+
+	ret, out := sliceForAppend(dst, len(plaintext)+ccmTagSize)
+	cipher.NewCTR(c.block, c.counter(nonce, 1)).XORKeyStream(out, plaintext)
+	copy(out[len(plaintext):], tag[:ccmTagSize])
+	return ret
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != ccmNonceSize {
+		panic("ccm: incorrect nonce length given to CCM")
+	}
+	if len(ciphertext) < ccmTagSize || len(ciphertext)-ccmTagSize > ccmMaxLength {
+		return nil, errCCMOpen
+	}
+
+	tagged := ciphertext[len(ciphertext)-ccmTagSize:]
+	ciphertext = ciphertext[:len(ciphertext)-ccmTagSize]
+
+	ret, out := sliceForAppend(dst, len(ciphertext))
+	cipher.NewCTR(c.block, c.counter(nonce, 1)).XORKeyStream(out, ciphertext)
+
+	tag := c.mac(nonce, out, additionalData)
+	s0 := make([]byte, 16)
+	c.block.Encrypt(s0, c.counter(nonce, 0))
+	subtle.XORBytes(tag, tag, s0)
+
+	if subtle.ConstantTimeCompare(tag[:ccmTagSize], tagged) != 1 {
+		clear(out)
+		return nil, errCCMOpen
+	}
+	return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the
+// extension, as the AEADs of the standard library do.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,199 @@
+package hpke
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+
+	circlkem "github.com/cloudflare/circl/kem"
+)
+
+//////////////////
+// KEMs from circl
+
+// circlKEMScheme exposes a KEM of circl, such as FrodoKEM, without changes:
+// the shared secret is that of the KEM, and keys use circl's encodings. Like
+// ML-KEM, it derives key pairs from the IKM with SHAKE256, as specified in
+// draft-ietf-hpke-pq, and has no Auth modes.
+type circlKEMScheme struct {
+	kemID  KEMID
+	scheme circlkem.Scheme
+}
+
+type circlPublicKey struct {
+	kemID KEMID
+	pub   circlkem.PublicKey
+}
+
+func (pub circlPublicKey) KEMID() KEMID {
+	return pub.kemID
+}
+
+func (pub circlPublicKey) Bytes() []byte {
+	enc, err := pub.pub.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return enc
+}
+
+func (pub circlPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*circlPublicKey)
+	return ok && pub.kemID == o.kemID && pub.pub.Equal(o.pub)
+}
+
+type circlPrivateKey struct {
+	kemID KEMID
+	priv  circlkem.PrivateKey
+}
+
+func (priv circlPrivateKey) KEMID() KEMID {
+	return priv.kemID
+}
+
+func (priv circlPrivateKey) Bytes() []byte {
+	enc, err := priv.priv.MarshalBinary()
+	if err != nil {
+		return nil
+	}
+	return enc
+}
+
+func (priv circlPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*circlPrivateKey)
+	return ok && priv.kemID == o.kemID && subtle.ConstantTimeCompare(priv.Bytes(), o.Bytes()) == 1
+}
+
+func (priv circlPrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
+}
+
+func (priv circlPrivateKey) PublicKey() KEMPublicKey {
+	return &circlPublicKey{priv.kemID, priv.priv.Public()}
+}
+
+func (s circlKEMScheme) ID() KEMID {
+	return s.kemID
+}
+
+// GenerateKeyPair seeds key generation from rand, since the GenerateKeyPair
+// of circl always uses crypto/rand.
+func (s circlKEMScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := make([]byte, s.scheme.SeedSize())
+	if _, err := io.ReadFull(rand, seed); err != nil {
+		return nil, nil, err
+	}
+
+	return s.newKeyPair(seed)
+}
+
+// DeriveKeyPair expands the IKM into a key generation seed with SHAKE256,
+// independently of the ciphersuite's KDF.
+func (s circlKEMScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := shake256LabeledDerive(kemSuiteID(s.ID()), ikm, "DeriveKeyPair", nil, s.scheme.SeedSize())
+	return s.newKeyPair(seed)
+}
+
+func (s circlKEMScheme) newKeyPair(seed []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	_, sk := s.scheme.DeriveKeyPair(seed)
+	priv := &circlPrivateKey{s.kemID, sk}
+	return priv, priv.PublicKey(), nil
+}
+
+// Marshal and MarshalPrivate fail with ErrKeyMismatch for keys of other KEMs.
+func (s circlKEMScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*circlPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, pk)
+	}
+	return raw.Bytes(), nil
+}
+
+func (s circlKEMScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*circlPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, sk)
+	}
+	return raw.Bytes(), nil
+}
+
+func (s circlKEMScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != s.PublicKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPublicKey, len(enc), s.PublicKeySize())
+	}
+
+	pub, err := s.scheme.UnmarshalBinaryPublicKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+	}
+
+	return &circlPublicKey{s.kemID, pub}, nil
+}
+
+func (s circlKEMScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	if len(enc) != s.PrivateKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPrivateKey, len(enc), s.PrivateKeySize())
+	}
+
+	priv, err := s.scheme.UnmarshalBinaryPrivateKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPrivateKey, err)
+	}
+
+	return &circlPrivateKey{s.kemID, priv}, nil
+}
+
+func (s circlKEMScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	ikmE := make([]byte, s.EncapSeedSize())
+	if _, err := io.ReadFull(rand, ikmE); err != nil {
+		return nil, nil, err
+	}
+
+	return s.EncapDeterministic(ikmE, pkR)
+}
+
+// EncapDeterministic uses ikmE as the encapsulation seed of circl.
+func (s circlKEMScheme) EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*circlPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, nil, keyMismatch(s.kemID, pkR)
+	}
+
+	if len(ikmE) != s.EncapSeedSize() {
+		return nil, nil, fmt.Errorf("Invalid encapsulation seed size: got %d, expected %d", len(ikmE), s.EncapSeedSize())
+	}
+
+	enc, sharedSecret, err := s.scheme.EncapsulateDeterministically(raw.pub, ikmE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sharedSecret, enc, nil
+}
+
+func (s circlKEMScheme) EncapSeedSize() int {
+	return s.scheme.EncapsulationSeedSize()
+}
+
+func (s circlKEMScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw, ok := skR.(*circlPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, skR)
+	}
+
+	if len(enc) != s.scheme.CiphertextSize() {
+		return nil, fmt.Errorf("%w: got %d bytes of ciphertext, expected %d", ErrInvalidKEMPublicKey, len(enc), s.scheme.CiphertextSize())
+	}
+
+	return s.scheme.Decapsulate(raw.priv, enc)
+}
+
+// PublicKeySize is that of circl, which is far larger than for the other KEMs,
+// e.g., 9616 bytes for FrodoKEM-640-SHAKE.
+func (s circlKEMScheme) PublicKeySize() int {
+	return s.scheme.PublicKeySize()
+}
+
+func (s circlKEMScheme) PrivateKeySize() int {
+	return s.scheme.PrivateKeySize()
+}
@@ -1,60 +1,113 @@
 package hpke
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/hmac"
+	"crypto/mlkem"
+	"crypto/sha3"
+	"crypto/subtle"
 	"encoding/binary"
 	"fmt"
+	"hash"
 	"io"
 	"math/big"
+	"sync"
 
 	_ "crypto/sha256"
-	_ "crypto/sha3"
 	_ "crypto/sha512"
 
 	"git.schwanenlied.me/yawning/x448.git"
-	"github.com/cloudflare/circl/dh/sidh"
+	circlkem "github.com/cloudflare/circl/kem"
+	"github.com/cloudflare/circl/kem/frodo/frodo640shake"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem1024"
+	"github.com/cloudflare/circl/kem/mlkem/mlkem768"
+	circlsign "github.com/cloudflare/circl/sign"
+	"github.com/tjfoc/gmsm/sm3"
+	"github.com/tjfoc/gmsm/sm4"
+	"gitlab.com/yawning/secp256k1-voi"
+	"gitlab.com/yawning/secp256k1-voi/secec"
 	"golang.org/x/crypto/chacha20poly1305"
 	"golang.org/x/crypto/curve25519"
 )
 
+// keyMismatch reports that key, a private or public key, does not belong to
+// the KEM identified by kemID.
+func keyMismatch(kemID KEMID, key interface{}) error {
+	return fmt.Errorf("%w: %T is not a key for KEM %04x", ErrKeyMismatch, key, kemID)
+}
+
 ////////
 // DHKEM
 
-type dhScheme interface {
+// DHGroup is a Diffie-Hellman group that NewDHKEM turns into a DHKEM, as in
+// RFC 9180, Section 4.1. ID returns the KEM identifier of the resulting
+// DHKEM, and DeriveKeyPair implements the group's DeriveKeyPair function
+// with the given KDF and KEM suite_id. DH must fail rather than return an
+// all-zero output.
+type DHGroup interface {
 	ID() KEMID
 	GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error)
-	Marshal(pk KEMPublicKey) []byte
+	DeriveKeyPair(kdf KDFScheme, suiteID []byte, ikm []byte) (KEMPrivateKey, KEMPublicKey, error)
+	Marshal(pk KEMPublicKey) ([]byte, error)
 	Unmarshal(enc []byte) (KEMPublicKey, error)
 	DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error)
 	PublicKeySize() int
+	PrivateKeySize() int
 
-	MarshalPrivate(sk KEMPrivateKey) []byte
+	MarshalPrivate(sk KEMPrivateKey) ([]byte, error)
 	UnmarshalPrivate(enc []byte) (KEMPrivateKey, error)
 }
 
 type dhkemScheme struct {
-	group dhScheme
-	KDF   KDFScheme
-	skE   KEMPrivateKey
+	group   DHGroup
+	KDF     KDFScheme
+	version Version
+}
+
+// NewDHKEM returns the DHKEM of RFC 9180 over group, with kdf as its KDF. The
+// result supports Auth mode and deterministic encapsulation, and can be made
+// available to AssembleCipherSuite with RegisterKEM.
+func NewDHKEM(group DHGroup, kdf KDFScheme) DeterministicAuthKEMScheme {
+	return &dhkemScheme{group: group, KDF: kdf}
 }
 
 func (s dhkemScheme) ID() KEMID {
 	return s.group.ID()
 }
 
+// kemSuiteID returns the identifier used to domain-separate a KEM's own
+// invocations of the KDF, i.e., "KEM" || I2OSP(kem_id, 2).
+func kemSuiteID(kemID KEMID) []byte {
+	suiteID := make([]byte, 5)
+	copy(suiteID, "KEM")
+	binary.BigEndian.PutUint16(suiteID[3:], uint16(kemID))
+	return suiteID
+}
+
+func (s dhkemScheme) suiteID() []byte {
+	return kemSuiteID(s.ID())
+}
+
 func (s dhkemScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
 	return s.group.GenerateKeyPair(rand)
 }
 
-func (s dhkemScheme) Marshal(pk KEMPublicKey) []byte {
+func (s dhkemScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	return s.group.DeriveKeyPair(s.KDF, s.suiteID(), ikm)
+}
+
+func (s dhkemScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
 	return s.group.Marshal(pk)
 }
 
-func (s dhkemScheme) MarshalPrivate(sk KEMPrivateKey) []byte {
+func (s dhkemScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
 	return s.group.MarshalPrivate(sk)
 }
 
@@ -66,45 +119,63 @@ func (s dhkemScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
 	return s.group.UnmarshalPrivate(enc)
 }
 
-func (s *dhkemScheme) setEphemeralKeyPair(skE KEMPrivateKey) {
-	s.skE = skE
+func (s dhkemScheme) extractAndExpand(dh []byte, kemContext []byte, Nsecret int) ([]byte, error) {
+	suiteID := s.suiteID()
+	eaePRK := s.version.labeledExtract(s.KDF, nil, suiteID, "eae_prk", dh)
+	return s.version.labeledExpand(s.KDF, eaePRK, suiteID, "shared_secret", kemContext, Nsecret)
 }
 
-func (s dhkemScheme) getEphemeralKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	if s.skE != nil {
-		return s.skE, s.skE.PublicKey(), nil
+func (s dhkemScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	skE, pkE, err := s.group.GenerateKeyPair(rand)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return s.group.GenerateKeyPair(rand)
+	return s.encap(skE, pkE, pkR)
+}
+
+// EncapDeterministic derives the ephemeral key pair from ikmE with
+// DeriveKeyPair, as the test vectors of RFC 9180 do.
+func (s dhkemScheme) EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	skE, pkE, err := s.DeriveKeyPair(ikmE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.encap(skE, pkE, pkR)
 }
 
-func (s dhkemScheme) extractAndExpand(dh []byte, kemContext []byte, Nzz int) []byte {
-	prk := s.KDF.LabeledExtract(nil, "dh", dh)
-	return s.KDF.LabeledExpand(prk, "prk", kemContext, Nzz)
+func (s dhkemScheme) EncapSeedSize() int {
+	return s.group.PrivateKeySize()
 }
 
-func (s dhkemScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
-	skE, pkE, err := s.getEphemeralKeyPair(rand)
+func (s dhkemScheme) encap(skE KEMPrivateKey, pkE KEMPublicKey, pkR KEMPublicKey) ([]byte, []byte, error) {
+	dh, err := s.group.DH(skE, pkR)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	dh, err := s.group.DH(skE, pkR)
+	enc, err := s.group.Marshal(pkE)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	enc := s.group.Marshal(pkE)
-	pkRm := s.group.Marshal(pkR)
+	pkRm, err := s.group.Marshal(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	kemContext := make([]byte, len(enc)+len(pkRm))
 	copy(kemContext, enc)
 	copy(kemContext[len(enc):], pkRm)
 
-	Nzz := s.KDF.OutputSize()
-	zz := s.extractAndExpand(dh, kemContext, Nzz)
+	Nsecret := s.KDF.OutputSize()
+	sharedSecret, err := s.extractAndExpand(dh, kemContext, Nsecret)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return zz, enc, nil
+	return sharedSecret, enc, nil
 }
 
 func (s dhkemScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
@@ -118,24 +189,38 @@ func (s dhkemScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
 		return nil, err
 	}
 
-	pkRm := s.group.Marshal(skR.PublicKey())
+	pkRm, err := s.group.Marshal(skR.PublicKey())
+	if err != nil {
+		return nil, err
+	}
 
 	kemContext := make([]byte, len(enc)+len(pkRm))
 	copy(kemContext, enc)
 	copy(kemContext[len(enc):], pkRm)
 
-	Nzz := s.KDF.OutputSize()
-	zz := s.extractAndExpand(dh, kemContext, Nzz)
-
-	return zz, nil
+	Nsecret := s.KDF.OutputSize()
+	return s.extractAndExpand(dh, kemContext, Nsecret)
 }
 
 func (s dhkemScheme) AuthEncap(rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error) {
-	skE, pkE, err := s.getEphemeralKeyPair(rand)
+	skE, pkE, err := s.group.GenerateKeyPair(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.authEncap(skE, pkE, pkR, skS)
+}
+
+func (s dhkemScheme) AuthEncapDeterministic(ikmE []byte, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error) {
+	skE, pkE, err := s.DeriveKeyPair(ikmE)
 	if err != nil {
 		return nil, nil, err
 	}
 
+	return s.authEncap(skE, pkE, pkR, skS)
+}
+
+func (s dhkemScheme) authEncap(skE KEMPrivateKey, pkE KEMPublicKey, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error) {
 	dhER, err := s.group.DH(skE, pkR)
 	if err != nil {
 		return nil, nil, err
@@ -148,9 +233,20 @@ func (s dhkemScheme) AuthEncap(rand io.Reader, pkR KEMPublicKey, skS KEMPrivateK
 
 	dh := append(dhER, dhIR...)
 
-	enc := s.group.Marshal(pkE)
-	pkRm := s.group.Marshal(pkR)
-	pkSm := s.group.Marshal(skS.PublicKey())
+	enc, err := s.group.Marshal(pkE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkRm, err := s.group.Marshal(pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkSm, err := s.group.Marshal(skS.PublicKey())
+	if err != nil {
+		return nil, nil, err
+	}
 
 	Nenc := len(enc)
 	Npk := len(pkRm)
@@ -160,10 +256,13 @@ func (s dhkemScheme) AuthEncap(rand io.Reader, pkR KEMPublicKey, skS KEMPrivateK
 	copy(kemContext[Nenc:Nenc+Npk], pkRm)
 	copy(kemContext[Nenc+Npk:], pkSm)
 
-	Nzz := s.KDF.OutputSize()
-	zz := s.extractAndExpand(dh, kemContext, Nzz)
+	Nsecret := s.KDF.OutputSize()
+	sharedSecret, err := s.extractAndExpand(dh, kemContext, Nsecret)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return zz, enc, nil
+	return sharedSecret, enc, nil
 }
 
 func (s dhkemScheme) AuthDecap(enc []byte, skR KEMPrivateKey, pkS KEMPublicKey) ([]byte, error) {
@@ -184,8 +283,15 @@ func (s dhkemScheme) AuthDecap(enc []byte, skR KEMPrivateKey, pkS KEMPublicKey)
 
 	dh := append(dhER, dhIR...)
 
-	pkRm := s.group.Marshal(skR.PublicKey())
-	pkSm := s.group.Marshal(pkS)
+	pkRm, err := s.group.Marshal(skR.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	pkSm, err := s.group.Marshal(pkS)
+	if err != nil {
+		return nil, err
+	}
 
 	Nenc := len(enc)
 	Npk := len(pkRm)
@@ -195,455 +301,1972 @@ func (s dhkemScheme) AuthDecap(enc []byte, skR KEMPrivateKey, pkS KEMPublicKey)
 	copy(kemContext[Nenc:Nenc+Npk], pkRm)
 	copy(kemContext[Nenc+Npk:], pkSm)
 
-	Nzz := s.KDF.OutputSize()
-	zz := s.extractAndExpand(dh, kemContext, Nzz)
-
-	return zz, nil
+	Nsecret := s.KDF.OutputSize()
+	return s.extractAndExpand(dh, kemContext, Nsecret)
 }
 
 func (s dhkemScheme) PublicKeySize() int {
 	return s.group.PublicKeySize()
 }
 
+func (s dhkemScheme) PrivateKeySize() int {
+	return s.group.PrivateKeySize()
+}
+
 ////////////////////////
 // ECDH with NIST curves
 
 type ecdhPrivateKey struct {
-	curve elliptic.Curve
-	d     []byte
-	x, y  *big.Int
+	priv *ecdh.PrivateKey
+}
+
+func (priv ecdhPrivateKey) KEMID() KEMID {
+	return ecdhScheme{curve: priv.priv.Curve()}.ID()
+}
+
+func (priv ecdhPrivateKey) Bytes() []byte {
+	return priv.priv.Bytes()
+}
+
+func (priv ecdhPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*ecdhPrivateKey)
+	return ok && priv.priv.Equal(o.priv)
+}
+
+func (priv ecdhPrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
 }
 
 func (priv ecdhPrivateKey) PublicKey() KEMPublicKey {
-	return &ecdhPublicKey{priv.curve, priv.x, priv.y}
+	return &ecdhPublicKey{priv.priv.PublicKey()}
 }
 
 type ecdhPublicKey struct {
-	curve elliptic.Curve
-	x, y  *big.Int
+	pub *ecdh.PublicKey
+}
+
+func (pub ecdhPublicKey) KEMID() KEMID {
+	return ecdhScheme{curve: pub.pub.Curve()}.ID()
+}
+
+func (pub ecdhPublicKey) Bytes() []byte {
+	return pub.pub.Bytes()
+}
+
+func (pub ecdhPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*ecdhPublicKey)
+	return ok && pub.pub.Equal(o.pub)
 }
 
 type ecdhScheme struct {
-	curve elliptic.Curve
-	skE   KEMPrivateKey
+	curve   ecdh.Curve
+	version Version
 }
 
+// ID returns the reserved identifier 0 for curves other than the NIST ones,
+// which GenerateKeyPair and DeriveKeyPair reject.
 func (s ecdhScheme) ID() KEMID {
-	switch s.curve.Params().Name {
-	case "P-256":
+	switch s.curve {
+	case ecdh.P256():
 		return DHKEM_P256
-	case "P-521":
+	case ecdh.P384():
+		return DHKEM_P384
+	case ecdh.P521():
 		return DHKEM_P521
 	}
-	panic(fmt.Sprintf("Unsupported curve: %s", s.curve.Params().Name))
+	return 0
+}
+
+// bitSize returns the bit length of the order of the curve.
+func (s ecdhScheme) bitSize() int {
+	switch s.curve {
+	case ecdh.P256():
+		return 256
+	case ecdh.P384():
+		return 384
+	case ecdh.P521():
+		return 521
+	}
+	return 0
 }
 
+// GenerateKeyPair samples scalars from rand until one is in range, so that
+// the key pair is fully determined by rand.
 func (s ecdhScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	d, x, y, err := elliptic.GenerateKey(s.curve, rand)
-	if err != nil {
-		return nil, nil, err
+	if s.ID() == 0 {
+		return nil, nil, fmt.Errorf("Unsupported curve: %v", s.curve)
 	}
 
-	priv := &ecdhPrivateKey{s.curve, d, x, y}
-	return priv, priv.PublicKey(), nil
+	Nsk := s.PrivateKeySize()
+	bitmask := byte(0xFF >> (8*Nsk - s.bitSize()))
+
+	skm := make([]byte, Nsk)
+	for {
+		if _, err := io.ReadFull(rand, skm); err != nil {
+			return nil, nil, err
+		}
+		skm[0] &= bitmask
+
+		priv, err := s.UnmarshalPrivate(skm)
+		if err != nil {
+			continue
+		}
+		return priv, priv.PublicKey(), nil
+	}
 }
 
-func (s ecdhScheme) Marshal(pk KEMPublicKey) []byte {
-	if pk == nil {
-		return nil
+// DeriveKeyPair implements the rejection sampling of RFC 9180, Section 7.1.3.
+// crypto/ecdh rejects candidates that are zero or not less than the order.
+func (s ecdhScheme) DeriveKeyPair(kdf KDFScheme, suiteID []byte, ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	if s.ID() == 0 {
+		return nil, nil, fmt.Errorf("Unsupported curve: %v", s.curve)
 	}
-	raw := pk.(*ecdhPublicKey)
-	return elliptic.Marshal(raw.curve, raw.x, raw.y)
+
+	dkpPRK := kdf.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
+
+	Nsk := s.PrivateKeySize()
+	bitmask := byte(0xFF >> (8*Nsk - s.bitSize()))
+
+	for counter := 0; counter < 256; counter++ {
+		skm, err := kdf.LabeledExpand(dkpPRK, suiteID, "candidate", []byte{byte(counter)}, Nsk)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		skm[0] &= bitmask
+
+		priv, err := s.UnmarshalPrivate(skm)
+		if err != nil {
+			continue
+		}
+		return priv, priv.PublicKey(), nil
+	}
+
+	return nil, nil, fmt.Errorf("Error deriving key pair")
 }
 
-func (s ecdhScheme) MarshalPrivate(sk KEMPrivateKey) []byte {
-	if sk == nil {
-		return nil
+// Marshal and MarshalPrivate fail with ErrKeyMismatch for keys on other curves.
+func (s ecdhScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*ecdhPublicKey)
+	if !ok || raw.pub.Curve() != s.curve {
+		return nil, keyMismatch(s.ID(), pk)
 	}
+	return raw.pub.Bytes(), nil
+}
 
-	raw := sk.(*ecdhPrivateKey)
-	copied := make([]byte, len(raw.d))
-	copy(copied, raw.d)
-	return copied
+func (s ecdhScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*ecdhPrivateKey)
+	if !ok || raw.priv.Curve() != s.curve {
+		return nil, keyMismatch(s.ID(), sk)
+	}
+	return raw.priv.Bytes(), nil
 }
 
+// Unmarshal accepts only uncompressed points that are on the curve and are
+// not the point at infinity.
 func (s ecdhScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
-	x, y := elliptic.Unmarshal(s.curve, enc)
-	if x == nil {
-		return nil, fmt.Errorf("Error unmarshaling public key")
+	pub, err := s.curve.NewPublicKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
 	}
 
-	return &ecdhPublicKey{s.curve, x, y}, nil
+	return &ecdhPublicKey{pub}, nil
 }
 
 func (s ecdhScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
-	if enc == nil {
-		return nil, fmt.Errorf("Invalid input")
+	priv, err := s.curve.NewPrivateKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPrivateKey, err)
 	}
 
-	x, y := s.curve.Params().ScalarBaseMult(enc)
-	return &ecdhPrivateKey{s.curve, enc, x, y}, nil
+	return &ecdhPrivateKey{priv}, nil
 }
 
 func (s ecdhScheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
 	ecdhPriv, ok := priv.(*ecdhPrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("Private key not suitable for ECDH")
+	if !ok || ecdhPriv.priv.Curve() != s.curve {
+		return nil, keyMismatch(s.ID(), priv)
 	}
 
 	ecdhPub, ok := pub.(*ecdhPublicKey)
-	if !ok {
-		return nil, fmt.Errorf("Public key not suitable for ECDH")
+	if !ok || ecdhPub.pub.Curve() != s.curve {
+		return nil, keyMismatch(s.ID(), pub)
+	}
+
+	// VersionDraft used the whole encoded point as the DH output, which
+	// crypto/ecdh does not expose.
+	if s.version == VersionDraft {
+		return s.draftDH(ecdhPriv, ecdhPub)
 	}
 
-	x, y := s.curve.Params().ScalarMult(ecdhPub.x, ecdhPub.y, ecdhPriv.d)
-	dh := elliptic.Marshal(ecdhPub.curve, x, y)
+	// ECDH fails if the result is the point at infinity
+	dh, err := ecdhPriv.priv.ECDH(ecdhPub.pub)
+	if err != nil {
+		return nil, fmt.Errorf("Error performing ECDH: %v", err)
+	}
 
 	return dh, nil
 }
 
-func (s ecdhScheme) PublicKeySize() int {
-	feSize := (s.curve.Params().BitSize + 7) >> 3
-	return 1 + 2*feSize
-}
+// draftDH returns the encoded shared point used by VersionDraft. It uses the
+// constant-time crypto/elliptic curves, not their generic CurveParams.
+func (s ecdhScheme) draftDH(priv *ecdhPrivateKey, pub *ecdhPublicKey) ([]byte, error) {
+	var curve elliptic.Curve
+	switch s.curve {
+	case ecdh.P256():
+		curve = elliptic.P256()
+	case ecdh.P384():
+		curve = elliptic.P384()
+	case ecdh.P521():
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("Unsupported curve: %v", s.curve)
+	}
 
-///////////////////
-// ECDH with X25519
+	x, y := elliptic.Unmarshal(curve, pub.pub.Bytes())
+	x, y = curve.ScalarMult(x, y, priv.priv.Bytes())
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, fmt.Errorf("Error performing ECDH: result is the point at infinity")
+	}
 
-type x25519PrivateKey struct {
-	val [32]byte
+	return elliptic.Marshal(curve, x, y), nil
 }
 
-func (priv x25519PrivateKey) PublicKey() KEMPublicKey {
-	pub := &x25519PublicKey{}
-	curve25519.ScalarBaseMult(&pub.val, &priv.val)
-	return pub
+func (s ecdhScheme) PublicKeySize() int {
+	return 1 + 2*s.PrivateKeySize()
 }
 
-type x25519PublicKey struct {
-	val [32]byte
+func (s ecdhScheme) PrivateKeySize() int {
+	return (s.bitSize() + 7) >> 3
 }
 
-type x25519Scheme struct {
-	skE KEMPrivateKey
-}
+//////////////////////////////////
+// ECDH with other Weierstrass curves
 
-func (s x25519Scheme) ID() KEMID {
-	return DHKEM_X25519
+// ellipticScheme is the DH group of a short Weierstrass curve that
+// crypto/ecdh does not implement, such as the SM2 curve of GB/T 32918.5-2017
+// or the brainpool curves of RFC 5639. Its arithmetic is the constant-time
+// implementation of weierstrassCurve.
+// Like the NIST curves, it encodes public keys as uncompressed SEC1 points and
+// uses the x-coordinate of the shared point as the DH output.
+type ellipticScheme struct {
+	kemID KEMID
+	curve *weierstrassCurve
 }
 
-func (s x25519Scheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	priv := &x25519PrivateKey{}
-	_, err := rand.Read(priv.val[:])
-	if err != nil {
-		return nil, nil, err
-	}
+// sm2P256 is the curve of GB/T 32918.5-2017, the same curve as sm2.P256Sm2.
+var sm2P256 = newWeierstrassCurve("SM2 P-256",
+	"fffffffeffffffffffffffffffffffffffffffff00000000ffffffffffffffff",
+	"fffffffeffffffffffffffffffffffffffffffff00000000fffffffffffffffc",
+	"28e9fa9e9d9f5e344d5a9e4bcf6509a7f39789f515ab8f92ddbcbd414d940e93",
+	"32c4ae2c1f1981195f9904466a39c9948fe30bbff2660be1715a4589334c74c7",
+	"bc3736a2f4f6779c59bdcee36b692153d0a9877cc62a474002df32e52139f0a0",
+	"fffffffeffffffffffffffffffffffff7203df6b21c6052b53bbf40939d54123")
 
-	return priv, priv.PublicKey(), nil
+type ellipticPrivateKey struct {
+	kemID KEMID
+	d     []byte
+	pub   *ellipticPublicKey
 }
 
-func (s x25519Scheme) Marshal(pk KEMPublicKey) []byte {
-	if pk == nil {
-		return nil
-	}
-	raw := pk.(*x25519PublicKey)
-	return raw.val[:]
+func (priv ellipticPrivateKey) KEMID() KEMID {
+	return priv.kemID
 }
 
-func (s x25519Scheme) MarshalPrivate(sk KEMPrivateKey) []byte {
-	if sk == nil {
-		return nil
-	}
-	raw := sk.(*x25519PrivateKey)
-	return raw.val[:]
+func (priv ellipticPrivateKey) Bytes() []byte {
+	return bytes.Clone(priv.d)
 }
 
-func (s x25519Scheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
-	if len(enc) != 32 {
-		return nil, fmt.Errorf("Error unmarshaling X25519 public key")
-	}
-
-	pub := &x25519PublicKey{}
-	copy(pub.val[:], enc)
-	return pub, nil
+func (priv ellipticPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*ellipticPrivateKey)
+	return ok && priv.kemID == o.kemID && subtle.ConstantTimeCompare(priv.d, o.d) == 1
 }
 
-func (s x25519Scheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
-	if enc == nil {
-		return nil, fmt.Errorf("Invalid input")
-	}
+func (priv ellipticPrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
+}
 
-	if len(enc) != 32 {
-		return nil, fmt.Errorf("Error unmarshaling X25519 private key")
-	}
+func (priv ellipticPrivateKey) PublicKey() KEMPublicKey {
+	return priv.pub
+}
 
-	key := &x25519PrivateKey{}
-	copy(key.val[:], enc[0:32])
-	return key, nil
+type ellipticPublicKey struct {
+	kemID KEMID
+	point []byte
 }
 
-func (s x25519Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
-	xPriv, ok := priv.(*x25519PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("Private key not suitable for X25519: %+v", priv)
-	}
+func (pub ellipticPublicKey) KEMID() KEMID {
+	return pub.kemID
+}
 
-	xPub, ok := pub.(*x25519PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("Private key not suitable for X25519")
-	}
+func (pub ellipticPublicKey) Bytes() []byte {
+	return bytes.Clone(pub.point)
+}
 
-	// TODO ScalarMult
-	var zz [32]byte
-	curve25519.ScalarMult(&zz, &xPriv.val, &xPub.val)
-	return zz[:], nil
+func (pub ellipticPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*ellipticPublicKey)
+	return ok && pub.kemID == o.kemID && bytes.Equal(pub.point, o.point)
 }
 
-func (s x25519Scheme) PublicKeySize() int {
-	return 32
+func (s ellipticScheme) ID() KEMID {
+	return s.kemID
 }
 
-///////////////////
-// ECDH with X448
+// GenerateKeyPair samples scalars from rand until one is in range, so that
+// the key pair is fully determined by rand.
+func (s ellipticScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	Nsk := s.PrivateKeySize()
+	bitmask := byte(0xFF >> (8*Nsk - s.curve.n.BitLen()))
 
-type x448PrivateKey struct {
-	val [56]byte
-}
+	skm := make([]byte, Nsk)
+	for {
+		if _, err := io.ReadFull(rand, skm); err != nil {
+			return nil, nil, err
+		}
+		skm[0] &= bitmask
 
-func (priv x448PrivateKey) PublicKey() KEMPublicKey {
-	pub := &x448PublicKey{}
-	x448.ScalarBaseMult(&pub.val, &priv.val)
-	return pub
+		priv, err := s.UnmarshalPrivate(skm)
+		if err != nil {
+			continue
+		}
+		return priv, priv.PublicKey(), nil
+	}
 }
 
-type x448PublicKey struct {
-	val [56]byte
-}
+// DeriveKeyPair implements the rejection sampling of RFC 9180, Section 7.1.3.
+func (s ellipticScheme) DeriveKeyPair(kdf KDFScheme, suiteID []byte, ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	dkpPRK := kdf.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
 
-type x448Scheme struct {
-	skE KEMPrivateKey
-}
+	Nsk := s.PrivateKeySize()
+	bitmask := byte(0xFF >> (8*Nsk - s.curve.n.BitLen()))
 
-func (s x448Scheme) ID() KEMID {
-	return DHKEM_X448
-}
+	for counter := 0; counter < 256; counter++ {
+		skm, err := kdf.LabeledExpand(dkpPRK, suiteID, "candidate", []byte{byte(counter)}, Nsk)
+		if err != nil {
+			return nil, nil, err
+		}
 
-func (s x448Scheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	priv := &x448PrivateKey{}
-	_, err := rand.Read(priv.val[:])
-	if err != nil {
-		return nil, nil, err
+		skm[0] &= bitmask
+
+		priv, err := s.UnmarshalPrivate(skm)
+		if err != nil {
+			continue
+		}
+		return priv, priv.PublicKey(), nil
 	}
 
-	return priv, priv.PublicKey(), nil
+	return nil, nil, fmt.Errorf("Error deriving key pair")
 }
 
-func (s x448Scheme) Marshal(pk KEMPublicKey) []byte {
-	if pk == nil {
-		return nil
+// Marshal and MarshalPrivate fail with ErrKeyMismatch for keys of other groups.
+func (s ellipticScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*ellipticPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, pk)
 	}
-	raw := pk.(*x448PublicKey)
-	return raw.val[:]
+	return raw.Bytes(), nil
 }
 
-func (s x448Scheme) MarshalPrivate(sk KEMPrivateKey) []byte {
-	if sk == nil {
-		return nil
+func (s ellipticScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*ellipticPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, sk)
 	}
-	raw := sk.(*x448PrivateKey)
-	return raw.val[:]
+	return raw.Bytes(), nil
 }
 
-func (s x448Scheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
-	if len(enc) != 56 {
-		return nil, fmt.Errorf("Error unmarshaling X448 public key")
+// Unmarshal accepts only uncompressed points that are on the curve. The
+// point at infinity has no such encoding.
+func (s ellipticScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != s.PublicKeySize() {
+		return nil, fmt.Errorf("%w: Invalid public key size %d != %d", ErrInvalidKEMPublicKey, len(enc), s.PublicKeySize())
 	}
 
-	pub := &x448PublicKey{}
-	copy(pub.val[:], enc)
-	return pub, nil
+	if _, err := s.curve.decodePoint(enc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+	}
+
+	return &ellipticPublicKey{kemID: s.kemID, point: bytes.Clone(enc)}, nil
 }
 
-func (s x448Scheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
-	if enc == nil {
-		return nil, fmt.Errorf("Invalid input")
+// UnmarshalPrivate accepts only scalars in [1, n-1], where n is the order of
+// the curve.
+func (s ellipticScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	if len(enc) != s.PrivateKeySize() {
+		return nil, fmt.Errorf("%w: Invalid private key size %d != %d", ErrInvalidKEMPrivateKey, len(enc), s.PrivateKeySize())
 	}
 
-	if len(enc) != 56 {
-		return nil, fmt.Errorf("Error unmarshaling X448 private key")
+	point, err := s.curve.ScalarBaseMult(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPrivateKey, err)
 	}
 
-	key := &x448PrivateKey{}
-	copy(key.val[:], enc[0:56])
-	return key, nil
+	pub := &ellipticPublicKey{kemID: s.kemID, point: point}
+	return &ellipticPrivateKey{kemID: s.kemID, d: bytes.Clone(enc), pub: pub}, nil
 }
 
-func (s x448Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
-	xPriv, ok := priv.(*x448PrivateKey)
-	if !ok {
-		return nil, fmt.Errorf("Private key not suitable for X448: %+v", priv)
+func (s ellipticScheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
+	ellipticPriv, ok := priv.(*ellipticPrivateKey)
+	if !ok || ellipticPriv.kemID != s.kemID {
+		return nil, keyMismatch(s.ID(), priv)
 	}
 
-	xPub, ok := pub.(*x448PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("Public key not suitable for X448: %+v", pub)
+	ellipticPub, ok := pub.(*ellipticPublicKey)
+	if !ok || ellipticPub.kemID != s.kemID {
+		return nil, keyMismatch(s.ID(), pub)
 	}
 
-	var zz [56]byte
-	x448.ScalarMult(&zz, &xPriv.val, &xPub.val)
-	return zz[:], nil
+	point, err := s.curve.ScalarMult(ellipticPriv.d, ellipticPub.point)
+	if err != nil {
+		return nil, fmt.Errorf("Error performing ECDH: %v", err)
+	}
+
+	return point[1 : 1+s.curve.coordinateSize()], nil
 }
 
-func (s x448Scheme) PublicKeySize() int {
-	return 56
+func (s ellipticScheme) PublicKeySize() int {
+	return 1 + 2*s.curve.coordinateSize()
 }
 
-///////
-// SIKE
+func (s ellipticScheme) PrivateKeySize() int {
+	return s.curve.scalarSize()
+}
 
-type sikePublicKey struct {
-	field uint8
-	pub   *sidh.PublicKey
+///////////////////////
+// ECDH with secp256k1
+
+type secp256k1PrivateKey struct {
+	kemID KEMID
+	priv  *secec.PrivateKey
 }
 
-type sikePrivateKey struct {
-	field uint8
-	priv  *sidh.PrivateKey
-	pub   *sidh.PublicKey
+func (priv secp256k1PrivateKey) KEMID() KEMID {
+	return priv.kemID
 }
 
-func (priv sikePrivateKey) PublicKey() KEMPublicKey {
-	return &sikePublicKey{priv.field, priv.pub}
+func (priv secp256k1PrivateKey) Bytes() []byte {
+	return priv.priv.Bytes()
 }
 
-type sikeScheme struct {
-	field uint8
-	KDF   KDFScheme
+func (priv secp256k1PrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*secp256k1PrivateKey)
+	return ok && priv.kemID == o.kemID && priv.priv.Equal(o.priv)
 }
 
-func (s sikeScheme) ID() KEMID {
-	switch s.field {
-	case sidh.Fp503:
-		return KEM_SIKE503
-	case sidh.Fp751:
-		return KEM_SIKE751
-	}
-	panic(fmt.Sprintf("Unsupported field: %d", s.field))
+func (priv secp256k1PrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
 }
 
-func (s sikeScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
-	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
-	err := rawPriv.Generate(rand)
-	if err != nil {
-		return nil, nil, err
-	}
+func (priv secp256k1PrivateKey) PublicKey() KEMPublicKey {
+	return &secp256k1PublicKey{kemID: priv.kemID, pub: priv.priv.PublicKey()}
+}
 
-	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
-	rawPriv.GeneratePublicKey(rawPub)
+type secp256k1PublicKey struct {
+	kemID KEMID
+	pub   *secec.PublicKey
+}
 
-	priv := &sikePrivateKey{s.field, rawPriv, rawPub}
-	return priv, priv.PublicKey(), nil
+func (pub secp256k1PublicKey) KEMID() KEMID {
+	return pub.kemID
 }
 
-func (s sikeScheme) Marshal(pk KEMPublicKey) []byte {
-	if pk == nil {
-		return nil
+// Bytes returns the compressed encoding for DHKEM_SECP256K1_COMPRESSED and
+// the uncompressed one otherwise.
+func (pub secp256k1PublicKey) Bytes() []byte {
+	if pub.kemID == DHKEM_SECP256K1_COMPRESSED {
+		return pub.pub.CompressedBytes()
 	}
-	raw := pk.(*sikePublicKey)
-	out := make([]byte, raw.pub.Size())
-	raw.pub.Export(out)
-	return out
+	return pub.pub.Bytes()
 }
 
-func (s sikeScheme) MarshalPrivate(sk KEMPrivateKey) []byte {
-	panic("Not implemented")
-	return nil
+func (pub secp256k1PublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*secp256k1PublicKey)
+	return ok && pub.kemID == o.kemID && pub.pub.Equal(o.pub)
 }
 
-func (s sikeScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
-	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
-	if len(enc) != rawPub.Size() {
-		return nil, fmt.Errorf("Invalid public key size: got %d, expected %d", len(enc), rawPub.Size())
-	}
+// secp256k1Scheme is the DH group of the secp256k1 curve of SEC 2, in
+// constant time. Public keys are SEC1 points, compressed for
+// DHKEM_SECP256K1_COMPRESSED and uncompressed for DHKEM_SECP256K1, and the DH
+// output is the x-coordinate of the shared point.
+type secp256k1Scheme struct {
+	compressed bool
+}
 
-	err := rawPub.Import(enc)
-	if err != nil {
-		return nil, err
+func (s secp256k1Scheme) ID() KEMID {
+	if s.compressed {
+		return DHKEM_SECP256K1_COMPRESSED
+	}
+	return DHKEM_SECP256K1
+}
+
+// GenerateKeyPair samples scalars from rand until one is in range, so that
+// the key pair is fully determined by rand.
+func (s secp256k1Scheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	skm := make([]byte, s.PrivateKeySize())
+	for {
+		if _, err := io.ReadFull(rand, skm); err != nil {
+			return nil, nil, err
+		}
+
+		priv, err := s.UnmarshalPrivate(skm)
+		if err != nil {
+			continue
+		}
+		return priv, priv.PublicKey(), nil
 	}
-
-	return &sikePublicKey{s.field, rawPub}, nil
 }
 
-func (s sikeScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
-	panic("Not implemented")
-	return nil, nil
-}
+// DeriveKeyPair implements the rejection sampling of RFC 9180, Section 7.1.3.
+// The order of secp256k1 is 256 bits long, so no bits are masked.
+func (s secp256k1Scheme) DeriveKeyPair(kdf KDFScheme, suiteID []byte, ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	dkpPRK := kdf.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
 
-func (s sikeScheme) newKEM(rand io.Reader) (*sidh.KEM, error) {
-	switch s.field {
-	case sidh.Fp503:
-		return sidh.NewSike503(rand), nil
-	case sidh.Fp751:
-		return sidh.NewSike751(rand), nil
+	for counter := 0; counter < 256; counter++ {
+		skm, err := kdf.LabeledExpand(dkpPRK, suiteID, "candidate", []byte{byte(counter)}, s.PrivateKeySize())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		priv, err := s.UnmarshalPrivate(skm)
+		if err != nil {
+			continue
+		}
+		return priv, priv.PublicKey(), nil
 	}
-	return nil, fmt.Errorf("Invalid field")
+
+	return nil, nil, fmt.Errorf("Error deriving key pair")
 }
 
-func (s sikeScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
-	raw := pkR.(*sikePublicKey)
+// Marshal and MarshalPrivate fail with ErrKeyMismatch for keys of other groups.
+func (s secp256k1Scheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*secp256k1PublicKey)
+	if !ok || raw.kemID != s.ID() {
+		return nil, keyMismatch(s.ID(), pk)
+	}
+	return raw.Bytes(), nil
+}
 
-	kem, err := s.newKEM(rand)
-	if err != nil {
-		return nil, nil, err
+func (s secp256k1Scheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*secp256k1PrivateKey)
+	if !ok || raw.kemID != s.ID() {
+		return nil, keyMismatch(s.ID(), sk)
 	}
+	return raw.Bytes(), nil
+}
 
-	enc := make([]byte, kem.CiphertextSize())
-	zz := make([]byte, s.KDF.OutputSize())
-	err = kem.Encapsulate(enc, zz, raw.pub)
+// Unmarshal accepts both compressed and uncompressed points, so that keys
+// stored in either encoding can be used. Marshal always produces the
+// encoding of the KEM, and Decap binds the encapsulation as received, so an
+// encapsulation re-encoded in transit fails to decrypt.
+func (s secp256k1Scheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	pub, err := secec.NewPublicKey(enc)
 	if err != nil {
-		return nil, nil, err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
 	}
 
-	return zz, enc, nil
+	return &secp256k1PublicKey{kemID: s.ID(), pub: pub}, nil
 }
 
-type panicReader struct{}
+func (s secp256k1Scheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	priv, err := secec.NewPrivateKey(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPrivateKey, err)
+	}
 
-func (p panicReader) Read(unused []byte) (int, error) {
-	panic("Should not read")
+	return &secp256k1PrivateKey{kemID: s.ID(), priv: priv}, nil
 }
 
-func (s sikeScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
-	raw := skR.(*sikePrivateKey)
+func (s secp256k1Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
+	secpPriv, ok := priv.(*secp256k1PrivateKey)
+	if !ok || secpPriv.kemID != s.ID() {
+		return nil, keyMismatch(s.ID(), priv)
+	}
 
-	kem, err := s.newKEM(panicReader{})
-	if err != nil {
-		return nil, err
+	secpPub, ok := pub.(*secp256k1PublicKey)
+	if !ok || secpPub.kemID != s.ID() {
+		return nil, keyMismatch(s.ID(), pub)
 	}
 
-	zz := make([]byte, s.KDF.OutputSize())
-	err = kem.Decapsulate(zz, raw.priv, raw.pub, enc)
+	dh, err := secpPriv.priv.ECDH(secpPub.pub)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("Error performing ECDH: %v", err)
 	}
-	//shared secret key
-	return zz, nil
+
+	return dh, nil
 }
 
-func (s sikeScheme) PublicKeySize() int {
-	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
-	return rawPub.Size()
+func (s secp256k1Scheme) PublicKeySize() int {
+	if s.compressed {
+		return secp256k1.CompressedPointSize
+	}
+	return secp256k1.UncompressedPointSize
 }
 
-func (s sikeScheme) setEphemeralKeyPair(skE KEMPrivateKey) {
-	panic("SIKE cannot use a pre-set ephemeral key pair")
+func (s secp256k1Scheme) PrivateKeySize() int {
+	return secec.PrivateKeySize
 }
 
-//////////
-// AES-GCM
+///////////////////
+// ECDH with X25519
 
-type aesgcmScheme struct {
-	keySize int
+// Private keys are kept and serialized as the raw scalar, as in RFC 9180.
+// Clamping is applied by the scalar multiplication itself, both when
+// computing the public key and in DH.
+type x25519PrivateKey struct {
+	val [32]byte
+}
+
+func (priv x25519PrivateKey) KEMID() KEMID {
+	return DHKEM_X25519
+}
+
+func (priv x25519PrivateKey) Bytes() []byte {
+	return priv.val[:]
+}
+
+func (priv x25519PrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*x25519PrivateKey)
+	return ok && subtle.ConstantTimeCompare(priv.val[:], o.val[:]) == 1
+}
+
+func (priv x25519PrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
+}
+
+func (priv x25519PrivateKey) PublicKey() KEMPublicKey {
+	pub := &x25519PublicKey{}
+	// X25519 only fails on a low-order input, which the base point is not
+	out, _ := curve25519.X25519(priv.val[:], curve25519.Basepoint)
+	copy(pub.val[:], out)
+	return pub
+}
+
+type x25519PublicKey struct {
+	val [32]byte
+}
+
+func (pub x25519PublicKey) KEMID() KEMID {
+	return DHKEM_X25519
+}
+
+func (pub x25519PublicKey) Bytes() []byte {
+	return pub.val[:]
+}
+
+func (pub x25519PublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*x25519PublicKey)
+	return ok && pub.val == o.val
+}
+
+var x25519Prime = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// x25519LowOrderPoints lists the u-coordinates of the points of order 1, 2,
+// 4 and 8 on Curve25519.
+var x25519LowOrderPoints = []*big.Int{
+	big.NewInt(0),
+	big.NewInt(1),
+	hexInt("00b8495f16056286fdb1329ceb8d09da6ac49ff1fae35616aeb8413b7c7aebe0"),
+	hexInt("57119fd0dd4e22d8868e1c58c45c44045bef839c55b1d0b1248c50a3bc959c5f"),
+	new(big.Int).Sub(x25519Prime, big.NewInt(1)),
+}
+
+type x25519Scheme struct {
+	rejectLowOrder bool
+}
+
+func (s x25519Scheme) ID() KEMID {
+	return DHKEM_X25519
+}
+
+func (s x25519Scheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	priv := &x25519PrivateKey{}
+	_, err := io.ReadFull(rand, priv.val[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+func (s x25519Scheme) DeriveKeyPair(kdf KDFScheme, suiteID []byte, ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	dkpPRK := kdf.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
+	skm, err := kdf.LabeledExpand(dkpPRK, suiteID, "sk", nil, s.PrivateKeySize())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := s.UnmarshalPrivate(skm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, priv.PublicKey(), nil
+}
+
+func (s x25519Scheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*x25519PublicKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), pk)
+	}
+	return raw.val[:], nil
+}
+
+func (s x25519Scheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*x25519PrivateKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), sk)
+	}
+	return raw.val[:], nil
+}
+
+// Unmarshal rejects the low-order points of Curve25519, including their
+// non-canonical encodings, if the scheme was configured to do so. Otherwise
+// they are only caught by DH.
+func (s x25519Scheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != 32 {
+		return nil, fmt.Errorf("%w: got %d bytes, expected 32", ErrInvalidKEMPublicKey, len(enc))
+	}
+
+	pub := &x25519PublicKey{}
+	copy(pub.val[:], enc)
+
+	if s.rejectLowOrder {
+		// X25519 ignores the most significant bit of the u-coordinate
+		u := pub.val
+		u[31] &= 0x7f
+		if isLowOrderPoint(u[:], x25519Prime, x25519LowOrderPoints) {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidKEMPublicKey, ErrLowOrderPoint)
+		}
+	}
+
+	return pub, nil
+}
+
+func (s x25519Scheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	if len(enc) != 32 {
+		return nil, fmt.Errorf("%w: got %d bytes, expected 32", ErrInvalidKEMPrivateKey, len(enc))
+	}
+
+	key := &x25519PrivateKey{}
+	copy(key.val[:], enc[0:32])
+	return key, nil
+}
+
+// DH fails with ErrLowOrderPoint if the output is all zero, as required by
+// RFC 9180, Section 7.1.4.
+func (s x25519Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
+	xPriv, ok := priv.(*x25519PrivateKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), priv)
+	}
+
+	xPub, ok := pub.(*x25519PublicKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), pub)
+	}
+
+	zz, err := curve25519.X25519(xPriv.val[:], xPub.val[:])
+	if err != nil {
+		return nil, ErrLowOrderPoint
+	}
+	return zz, nil
+}
+
+func (s x25519Scheme) PublicKeySize() int {
+	return 32
+}
+
+func (s x25519Scheme) PrivateKeySize() int {
+	return 32
+}
+
+///////////////////
+// ECDH with X448
+
+// As with X25519, private keys are kept unclamped and clamped by the scalar
+// multiplication.
+type x448PrivateKey struct {
+	val [56]byte
+}
+
+func (priv x448PrivateKey) KEMID() KEMID {
+	return DHKEM_X448
+}
+
+func (priv x448PrivateKey) Bytes() []byte {
+	return priv.val[:]
+}
+
+func (priv x448PrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*x448PrivateKey)
+	return ok && subtle.ConstantTimeCompare(priv.val[:], o.val[:]) == 1
+}
+
+func (priv x448PrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
+}
+
+func (priv x448PrivateKey) PublicKey() KEMPublicKey {
+	pub := &x448PublicKey{}
+	x448.ScalarBaseMult(&pub.val, &priv.val)
+	return pub
+}
+
+type x448PublicKey struct {
+	val [56]byte
+}
+
+func (pub x448PublicKey) KEMID() KEMID {
+	return DHKEM_X448
+}
+
+func (pub x448PublicKey) Bytes() []byte {
+	return pub.val[:]
+}
+
+func (pub x448PublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*x448PublicKey)
+	return ok && pub.val == o.val
+}
+
+var x448Prime = new(big.Int).Sub(new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 448), new(big.Int).Lsh(big.NewInt(1), 224)), big.NewInt(1))
+
+// x448LowOrderPoints lists the u-coordinates of the points of order 1, 2 and
+// 4 on Curve448.
+var x448LowOrderPoints = []*big.Int{
+	big.NewInt(0),
+	big.NewInt(1),
+	new(big.Int).Sub(x448Prime, big.NewInt(1)),
+}
+
+type x448Scheme struct {
+	rejectLowOrder bool
+}
+
+func (s x448Scheme) ID() KEMID {
+	return DHKEM_X448
+}
+
+func (s x448Scheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	priv := &x448PrivateKey{}
+	_, err := io.ReadFull(rand, priv.val[:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+func (s x448Scheme) DeriveKeyPair(kdf KDFScheme, suiteID []byte, ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	dkpPRK := kdf.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
+	skm, err := kdf.LabeledExpand(dkpPRK, suiteID, "sk", nil, s.PrivateKeySize())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := s.UnmarshalPrivate(skm)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv, priv.PublicKey(), nil
+}
+
+func (s x448Scheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*x448PublicKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), pk)
+	}
+	return raw.val[:], nil
+}
+
+func (s x448Scheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*x448PrivateKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), sk)
+	}
+	return raw.val[:], nil
+}
+
+// Unmarshal rejects the low-order points of Curve448, including their
+// non-canonical encodings, if the scheme was configured to do so.
+func (s x448Scheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != 56 {
+		return nil, fmt.Errorf("%w: got %d bytes, expected 56", ErrInvalidKEMPublicKey, len(enc))
+	}
+
+	pub := &x448PublicKey{}
+	copy(pub.val[:], enc)
+
+	if s.rejectLowOrder && isLowOrderPoint(pub.val[:], x448Prime, x448LowOrderPoints) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKEMPublicKey, ErrLowOrderPoint)
+	}
+
+	return pub, nil
+}
+
+func (s x448Scheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	if len(enc) != 56 {
+		return nil, fmt.Errorf("%w: got %d bytes, expected 56", ErrInvalidKEMPrivateKey, len(enc))
+	}
+
+	key := &x448PrivateKey{}
+	copy(key.val[:], enc[0:56])
+	return key, nil
+}
+
+// DH fails with ErrLowOrderPoint if the output is all zero, as required by
+// RFC 9180, Section 7.1.4.
+func (s x448Scheme) DH(priv KEMPrivateKey, pub KEMPublicKey) ([]byte, error) {
+	xPriv, ok := priv.(*x448PrivateKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), priv)
+	}
+
+	xPub, ok := pub.(*x448PublicKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), pub)
+	}
+
+	var zz, zero [56]byte
+	x448.ScalarMult(&zz, &xPriv.val, &xPub.val)
+	if subtle.ConstantTimeCompare(zz[:], zero[:]) == 1 {
+		return nil, ErrLowOrderPoint
+	}
+	return zz[:], nil
+}
+
+func (s x448Scheme) PublicKeySize() int {
+	return 56
+}
+
+func (s x448Scheme) PrivateKeySize() int {
+	return 56
+}
+
+// isLowOrderPoint reports whether the little-endian u-coordinate enc is, once
+// reduced modulo p, one of the given low-order points. Public keys are not
+// secret, so this need not run in constant time.
+func isLowOrderPoint(enc []byte, p *big.Int, lowOrderPoints []*big.Int) bool {
+	be := make([]byte, len(enc))
+	for i := range enc {
+		be[len(enc)-1-i] = enc[i]
+	}
+
+	u := new(big.Int).Mod(new(big.Int).SetBytes(be), p)
+	for _, point := range lowOrderPoints {
+		if u.Cmp(point) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func hexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic(fmt.Sprintf("Invalid hex integer: %s", s))
+	}
+	return n
+}
+
+// RejectLowOrderPoints returns a copy of suite whose KEM rejects the
+// low-order points of Curve25519 and Curve448 when unmarshaling public keys,
+// rather than only failing in DH. It has no effect on other KEMs, which
+// already validate their public keys.
+func RejectLowOrderPoints(suite CipherSuite) CipherSuite {
+	strict := func(group DHGroup) DHGroup {
+		switch g := group.(type) {
+		case x25519Scheme:
+			g.rejectLowOrder = true
+			return g
+		case x448Scheme:
+			g.rejectLowOrder = true
+			return g
+		}
+		return group
+	}
+
+	switch kem := suite.KEM.(type) {
+	case *dhkemScheme:
+		copied := *kem
+		copied.group = strict(kem.group)
+		suite.KEM = &copied
+	case *hybridScheme:
+		copied := *kem
+		copied.group = strict(kem.group)
+		suite.KEM = &copied
+	}
+	return suite
+}
+
+/////////
+// ML-KEM
+
+// mlkemEncapsulationKey and mlkemDecapsulationKey abstract over the
+// parameter-set-specific key types of crypto/mlkem.
+type mlkemEncapsulationKey interface {
+	Bytes() []byte
+	Encapsulate() (sharedKey, ciphertext []byte)
+}
+
+type mlkemDecapsulationKey interface {
+	Bytes() []byte
+	Decapsulate(ciphertext []byte) (sharedKey []byte, err error)
+}
+
+type mlkemPublicKey struct {
+	kemID KEMID
+	ek    mlkemEncapsulationKey
+}
+
+func (pub mlkemPublicKey) KEMID() KEMID {
+	return pub.kemID
+}
+
+func (pub mlkemPublicKey) Bytes() []byte {
+	return pub.ek.Bytes()
+}
+
+func (pub mlkemPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*mlkemPublicKey)
+	return ok && pub.kemID == o.kemID && bytes.Equal(pub.ek.Bytes(), o.ek.Bytes())
+}
+
+type mlkemPrivateKey struct {
+	kemID KEMID
+	dk    mlkemDecapsulationKey
+	pub   *mlkemPublicKey
+}
+
+func (priv mlkemPrivateKey) KEMID() KEMID {
+	return priv.kemID
+}
+
+func (priv mlkemPrivateKey) Bytes() []byte {
+	return priv.dk.Bytes()
+}
+
+func (priv mlkemPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*mlkemPrivateKey)
+	return ok && priv.kemID == o.kemID && subtle.ConstantTimeCompare(priv.dk.Bytes(), o.dk.Bytes()) == 1
+}
+
+func (priv mlkemPrivateKey) Public() KEMPublicKey {
+	return priv.pub
+}
+
+func (priv mlkemPrivateKey) PublicKey() KEMPublicKey {
+	return priv.pub
+}
+
+type mlkemScheme struct {
+	kemID KEMID
+}
+
+func (s mlkemScheme) ID() KEMID {
+	return s.kemID
+}
+
+func (s mlkemScheme) newPrivateKey(seed []byte) (*mlkemPrivateKey, error) {
+	switch s.kemID {
+	case KEM_MLKEM768:
+		dk, err := mlkem.NewDecapsulationKey768(seed)
+		if err != nil {
+			return nil, err
+		}
+		return &mlkemPrivateKey{s.kemID, dk, &mlkemPublicKey{s.kemID, dk.EncapsulationKey()}}, nil
+	case KEM_MLKEM1024:
+		dk, err := mlkem.NewDecapsulationKey1024(seed)
+		if err != nil {
+			return nil, err
+		}
+		return &mlkemPrivateKey{s.kemID, dk, &mlkemPublicKey{s.kemID, dk.EncapsulationKey()}}, nil
+	}
+	return nil, fmt.Errorf("Unsupported ML-KEM parameter set: %04x", s.kemID)
+}
+
+func (s mlkemScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := make([]byte, mlkem.SeedSize)
+	_, err := io.ReadFull(rand, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := s.newPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+// DeriveKeyPair expands the IKM into a 64-byte ML-KEM seed with SHAKE256, as
+// specified in draft-ietf-hpke-pq, independently of the ciphersuite's KDF.
+func (s mlkemScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := shake256LabeledDerive(kemSuiteID(s.ID()), ikm, "DeriveKeyPair", nil, mlkem.SeedSize)
+
+	priv, err := s.newPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+func (s mlkemScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*mlkemPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, pk)
+	}
+	return raw.ek.Bytes(), nil
+}
+
+// MarshalPrivate returns the 64-byte seed from which the key pair was
+// generated, which is the private key format used by draft-ietf-hpke-pq.
+func (s mlkemScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*mlkemPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, sk)
+	}
+	return raw.dk.Bytes(), nil
+}
+
+func (s mlkemScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != s.PublicKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPublicKey, len(enc), s.PublicKeySize())
+	}
+
+	switch s.kemID {
+	case KEM_MLKEM768:
+		ek, err := mlkem.NewEncapsulationKey768(enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+		}
+		return &mlkemPublicKey{s.kemID, ek}, nil
+	case KEM_MLKEM1024:
+		ek, err := mlkem.NewEncapsulationKey1024(enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+		}
+		return &mlkemPublicKey{s.kemID, ek}, nil
+	}
+	return nil, fmt.Errorf("Unsupported ML-KEM parameter set: %04x", s.kemID)
+}
+
+func (s mlkemScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	if len(enc) != mlkem.SeedSize {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPrivateKey, len(enc), mlkem.SeedSize)
+	}
+
+	priv, err := s.newPrivateKey(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return priv, nil
+}
+
+// Encap draws its randomness from crypto/rand, since crypto/mlkem does not
+// accept a caller-provided source; the rand argument is ignored.
+func (s mlkemScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*mlkemPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, nil, keyMismatch(s.kemID, pkR)
+	}
+
+	sharedSecret, enc := raw.ek.Encapsulate()
+	return sharedSecret, enc, nil
+}
+
+// EncapDeterministic uses ikmE as the 32-byte encapsulation randomness m of
+// FIPS 203. crypto/mlkem does not expose derandomized encapsulation, so this
+// goes through circl's implementation.
+func (s mlkemScheme) EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*mlkemPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, nil, keyMismatch(s.kemID, pkR)
+	}
+
+	if len(ikmE) != s.EncapSeedSize() {
+		return nil, nil, fmt.Errorf("Invalid encapsulation seed size: got %d, expected %d", len(ikmE), s.EncapSeedSize())
+	}
+
+	var scheme circlkem.Scheme
+	switch s.kemID {
+	case KEM_MLKEM768:
+		scheme = mlkem768.Scheme()
+	case KEM_MLKEM1024:
+		scheme = mlkem1024.Scheme()
+	default:
+		return nil, nil, fmt.Errorf("Unsupported ML-KEM parameter set: %04x", s.kemID)
+	}
+
+	pk, err := scheme.UnmarshalBinaryPublicKey(raw.ek.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc, sharedSecret, err := scheme.EncapsulateDeterministically(pk, ikmE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sharedSecret, enc, nil
+}
+
+func (s mlkemScheme) EncapSeedSize() int {
+	return 32
+}
+
+func (s mlkemScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw, ok := skR.(*mlkemPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, skR)
+	}
+
+	if len(enc) != s.ciphertextSize() {
+		return nil, fmt.Errorf("%w: got %d bytes of ciphertext, expected %d", ErrInvalidKEMPublicKey, len(enc), s.ciphertextSize())
+	}
+
+	ss, err := raw.dk.Decapsulate(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+	}
+	return ss, nil
+}
+
+func (s mlkemScheme) ciphertextSize() int {
+	switch s.kemID {
+	case KEM_MLKEM768:
+		return mlkem.CiphertextSize768
+	case KEM_MLKEM1024:
+		return mlkem.CiphertextSize1024
+	}
+	return 0
+}
+
+func (s mlkemScheme) PublicKeySize() int {
+	switch s.kemID {
+	case KEM_MLKEM768:
+		return mlkem.EncapsulationKeySize768
+	case KEM_MLKEM1024:
+		return mlkem.EncapsulationKeySize1024
+	}
+	return 0
+}
+
+func (s mlkemScheme) PrivateKeySize() int {
+	return mlkem.SeedSize
+}
+
+// shake256LabeledDerive implements the one-stage LabeledDerive function of
+// draft-ietf-hpke-pq with SHAKE256.
+func shake256LabeledDerive(suiteID []byte, ikm []byte, label string, context []byte, L int) []byte {
+	prefix := make([]byte, 2)
+	h := sha3.NewSHAKE256()
+	h.Write(ikm)
+	h.Write([]byte(rfcLabel))
+	h.Write(suiteID)
+	binary.BigEndian.PutUint16(prefix, uint16(len(label)))
+	h.Write(prefix)
+	h.Write([]byte(label))
+	binary.BigEndian.PutUint16(prefix, uint16(L))
+	h.Write(prefix)
+	h.Write(context)
+
+	out := make([]byte, L)
+	h.Read(out)
+	return out
+}
+
+/////////
+// X-Wing
+
+// xwingLabel is the domain separator of the X-Wing combiner.
+const xwingLabel = `\./` + `/^\`
+
+type xwingPublicKey struct {
+	pkM KEMPublicKey
+	pkX KEMPublicKey
+}
+
+func (pub xwingPublicKey) KEMID() KEMID {
+	return KEM_XWING
+}
+
+func (pub xwingPublicKey) Bytes() []byte {
+	return append(pub.pkM.Bytes(), pub.pkX.Bytes()...)
+}
+
+func (pub xwingPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*xwingPublicKey)
+	return ok && pub.pkM.Equal(o.pkM) && pub.pkX.Equal(o.pkX)
+}
+
+type xwingPrivateKey struct {
+	seed []byte
+	skM  KEMPrivateKey
+	skX  KEMPrivateKey
+	pub  *xwingPublicKey
+}
+
+func (priv xwingPrivateKey) KEMID() KEMID {
+	return KEM_XWING
+}
+
+func (priv xwingPrivateKey) Bytes() []byte {
+	return append([]byte{}, priv.seed...)
+}
+
+func (priv xwingPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*xwingPrivateKey)
+	return ok && subtle.ConstantTimeCompare(priv.seed, o.seed) == 1
+}
+
+func (priv xwingPrivateKey) Public() KEMPublicKey {
+	return priv.pub
+}
+
+func (priv xwingPrivateKey) PublicKey() KEMPublicKey {
+	return priv.pub
+}
+
+// xwingScheme implements the X-Wing hybrid KEM from
+// draft-connolly-cfrg-xwing-kem, which combines ML-KEM-768 with X25519.
+type xwingScheme struct {
+	pq    mlkemScheme
+	group x25519Scheme
+}
+
+func (s xwingScheme) ID() KEMID {
+	return KEM_XWING
+}
+
+// newPrivateKey expands a 32-byte X-Wing seed into the ML-KEM-768 and X25519
+// private keys.
+func (s xwingScheme) newPrivateKey(seed []byte) (*xwingPrivateKey, error) {
+	if len(seed) != s.PrivateKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPrivateKey, len(seed), s.PrivateKeySize())
+	}
+
+	expanded := make([]byte, s.pq.PrivateKeySize()+s.group.PrivateKeySize())
+	h := sha3.NewSHAKE256()
+	h.Write(seed)
+	h.Read(expanded)
+
+	skM, err := s.pq.UnmarshalPrivate(expanded[:s.pq.PrivateKeySize()])
+	if err != nil {
+		return nil, err
+	}
+
+	skX, err := s.group.UnmarshalPrivate(expanded[s.pq.PrivateKeySize():])
+	if err != nil {
+		return nil, err
+	}
+
+	pub := &xwingPublicKey{skM.PublicKey(), skX.PublicKey()}
+	return &xwingPrivateKey{append([]byte{}, seed...), skM, skX, pub}, nil
+}
+
+func (s xwingScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := make([]byte, s.PrivateKeySize())
+	_, err := io.ReadFull(rand, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := s.newPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+// DeriveKeyPair expands the IKM into an X-Wing seed with SHAKE256, as
+// specified in draft-ietf-hpke-pq.
+func (s xwingScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := shake256LabeledDerive(kemSuiteID(s.ID()), ikm, "DeriveKeyPair", nil, s.PrivateKeySize())
+
+	priv, err := s.newPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+func (s xwingScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*xwingPublicKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), pk)
+	}
+
+	pkMm, err := s.pq.Marshal(raw.pkM)
+	if err != nil {
+		return nil, err
+	}
+
+	pkXm, err := s.group.Marshal(raw.pkX)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(pkMm)+len(pkXm))
+	copy(out, pkMm)
+	copy(out[len(pkMm):], pkXm)
+	return out, nil
+}
+
+func (s xwingScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*xwingPrivateKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), sk)
+	}
+	return append([]byte{}, raw.seed...), nil
+}
+
+func (s xwingScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != s.PublicKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPublicKey, len(enc), s.PublicKeySize())
+	}
+
+	pkM, err := s.pq.Unmarshal(enc[:s.pq.PublicKeySize()])
+	if err != nil {
+		return nil, err
+	}
+
+	pkX, err := s.group.Unmarshal(enc[s.pq.PublicKeySize():])
+	if err != nil {
+		return nil, err
+	}
+
+	return &xwingPublicKey{pkM, pkX}, nil
+}
+
+func (s xwingScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	priv, err := s.newPrivateKey(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return priv, nil
+}
+
+// combiner derives the X-Wing shared secret as
+// SHA3-256(ss_M || ss_X || ct_X || pk_X || XWingLabel).
+func (s xwingScheme) combiner(ssM, ssX, ctX, pkX []byte) []byte {
+	h := sha3.New256()
+	h.Write(ssM)
+	h.Write(ssX)
+	h.Write(ctX)
+	h.Write(pkX)
+	h.Write([]byte(xwingLabel))
+	return h.Sum(nil)
+}
+
+func (s xwingScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*xwingPublicKey)
+	if !ok {
+		return nil, nil, keyMismatch(s.ID(), pkR)
+	}
+
+	ssM, ctM, err := s.pq.Encap(rand, raw.pkM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skE, _, err := s.group.GenerateKeyPair(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.encap(raw, ssM, ctM, skE)
+}
+
+// EncapDeterministic implements EncapsulateDerand of X-Wing: the first 32
+// bytes of ikmE are the ML-KEM randomness and the last 32 bytes are the
+// ephemeral X25519 private key.
+func (s xwingScheme) EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*xwingPublicKey)
+	if !ok {
+		return nil, nil, keyMismatch(s.ID(), pkR)
+	}
+
+	if len(ikmE) != s.EncapSeedSize() {
+		return nil, nil, fmt.Errorf("Invalid encapsulation seed size: got %d, expected %d", len(ikmE), s.EncapSeedSize())
+	}
+
+	NseedM := s.pq.EncapSeedSize()
+	ssM, ctM, err := s.pq.EncapDeterministic(ikmE[:NseedM], raw.pkM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skE, err := s.group.UnmarshalPrivate(ikmE[NseedM:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.encap(raw, ssM, ctM, skE)
+}
+
+func (s xwingScheme) EncapSeedSize() int {
+	return s.pq.EncapSeedSize() + s.group.PrivateKeySize()
+}
+
+func (s xwingScheme) encap(raw *xwingPublicKey, ssM, ctM []byte, skE KEMPrivateKey) ([]byte, []byte, error) {
+	ssX, err := s.group.DH(skE, raw.pkX)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctX, err := s.group.Marshal(skE.PublicKey())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkXm, err := s.group.Marshal(raw.pkX)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedSecret := s.combiner(ssM, ssX, ctX, pkXm)
+
+	enc := make([]byte, len(ctM)+len(ctX))
+	copy(enc, ctM)
+	copy(enc[len(ctM):], ctX)
+	return sharedSecret, enc, nil
+}
+
+func (s xwingScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw, ok := skR.(*xwingPrivateKey)
+	if !ok {
+		return nil, keyMismatch(s.ID(), skR)
+	}
+
+	NctM := mlkem.CiphertextSize768
+	if len(enc) != NctM+s.group.PublicKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes of encapsulated key, expected %d", ErrInvalidKEMPublicKey, len(enc), NctM+s.group.PublicKeySize())
+	}
+
+	ssM, err := s.pq.Decap(enc[:NctM], raw.skM)
+	if err != nil {
+		return nil, err
+	}
+
+	ctX := enc[NctM:]
+	pkE, err := s.group.Unmarshal(ctX)
+	if err != nil {
+		return nil, err
+	}
+
+	ssX, err := s.group.DH(raw.skX, pkE)
+	if err != nil {
+		return nil, err
+	}
+
+	pkXm, err := s.group.Marshal(raw.pub.pkX)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.combiner(ssM, ssX, ctX, pkXm), nil
+}
+
+func (s xwingScheme) PublicKeySize() int {
+	return s.pq.PublicKeySize() + s.group.PublicKeySize()
+}
+
+func (s xwingScheme) PrivateKeySize() int {
+	return 32
+}
+
+/////////////
+// Hybrid KEM
+
+type hybridPublicKey struct {
+	kemID KEMID
+	pkPQ  KEMPublicKey
+	pkT   KEMPublicKey
+}
+
+func (pub hybridPublicKey) KEMID() KEMID {
+	return pub.kemID
+}
+
+func (pub hybridPublicKey) Bytes() []byte {
+	return append(pub.pkPQ.Bytes(), pub.pkT.Bytes()...)
+}
+
+func (pub hybridPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*hybridPublicKey)
+	return ok && pub.kemID == o.kemID && pub.pkPQ.Equal(o.pkPQ) && pub.pkT.Equal(o.pkT)
+}
+
+type hybridPrivateKey struct {
+	kemID KEMID
+	seed  []byte
+	skPQ  KEMPrivateKey
+	skT   KEMPrivateKey
+	pub   *hybridPublicKey
+}
+
+func (priv hybridPrivateKey) KEMID() KEMID {
+	return priv.kemID
+}
+
+func (priv hybridPrivateKey) Bytes() []byte {
+	return append([]byte{}, priv.seed...)
+}
+
+func (priv hybridPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*hybridPrivateKey)
+	return ok && priv.kemID == o.kemID && subtle.ConstantTimeCompare(priv.seed, o.seed) == 1
+}
+
+func (priv hybridPrivateKey) Public() KEMPublicKey {
+	return priv.pub
+}
+
+func (priv hybridPrivateKey) PublicKey() KEMPublicKey {
+	return priv.pub
+}
+
+// hybridScheme combines a DH group with a post-quantum KEM following the
+// hybrid construction of draft-ietf-hpke-pq. Public keys and encapsulations
+// are the concatenation of the PQ and traditional parts, and the shared secret
+// is Hash(ss_PQ || ss_T || ct_T || pk_T || label), computed with the KDF's hash
+// function.
+type hybridScheme struct {
+	kemID KEMID
+	label string
+	group DHGroup
+	pq    KEMScheme
+	KDF   KDFScheme
+}
+
+func (s hybridScheme) ID() KEMID {
+	return s.kemID
+}
+
+// newPrivateKey expands a 32-byte seed with SHAKE256 into the PQ private key,
+// followed by the traditional private key using rejection sampling.
+func (s hybridScheme) newPrivateKey(seed []byte) (*hybridPrivateKey, error) {
+	if len(seed) != s.PrivateKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPrivateKey, len(seed), s.PrivateKeySize())
+	}
+
+	h := sha3.NewSHAKE256()
+	h.Write(seed)
+
+	skPQ, pkPQ, err := s.pq.GenerateKeyPair(h)
+	if err != nil {
+		return nil, err
+	}
+
+	var skT KEMPrivateKey
+	skTm := make([]byte, s.group.PrivateKeySize())
+	for skT == nil {
+		h.Read(skTm)
+		skT, _ = s.group.UnmarshalPrivate(skTm)
+	}
+
+	pub := &hybridPublicKey{s.kemID, pkPQ, skT.PublicKey()}
+	return &hybridPrivateKey{s.kemID, append([]byte{}, seed...), skPQ, skT, pub}, nil
+}
+
+func (s hybridScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := make([]byte, s.PrivateKeySize())
+	_, err := io.ReadFull(rand, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	priv, err := s.newPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+func (s hybridScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	seed := shake256LabeledDerive(kemSuiteID(s.ID()), ikm, "DeriveKeyPair", nil, s.PrivateKeySize())
+
+	priv, err := s.newPrivateKey(seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return priv, priv.PublicKey(), nil
+}
+
+func (s hybridScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*hybridPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, pk)
+	}
+
+	pkPQm, err := s.pq.Marshal(raw.pkPQ)
+	if err != nil {
+		return nil, err
+	}
+
+	pkTm, err := s.group.Marshal(raw.pkT)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(pkPQm)+len(pkTm))
+	copy(out, pkPQm)
+	copy(out[len(pkPQm):], pkTm)
+	return out, nil
+}
+
+func (s hybridScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*hybridPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, sk)
+	}
+	return append([]byte{}, raw.seed...), nil
+}
+
+func (s hybridScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	if len(enc) != s.PublicKeySize() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPublicKey, len(enc), s.PublicKeySize())
+	}
+
+	pkPQ, err := s.pq.Unmarshal(enc[:s.pq.PublicKeySize()])
+	if err != nil {
+		return nil, err
+	}
+
+	pkT, err := s.group.Unmarshal(enc[s.pq.PublicKeySize():])
+	if err != nil {
+		return nil, err
+	}
+
+	return &hybridPublicKey{s.kemID, pkPQ, pkT}, nil
+}
+
+func (s hybridScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	priv, err := s.newPrivateKey(enc)
+	if err != nil {
+		return nil, err
+	}
+
+	return priv, nil
+}
+
+func (s hybridScheme) combiner(ssPQ, ssT, ctT, pkT []byte) []byte {
+	message := make([]byte, 0, len(ssPQ)+len(ssT)+len(ctT)+len(pkT)+len(s.label))
+	message = append(message, ssPQ...)
+	message = append(message, ssT...)
+	message = append(message, ctT...)
+	message = append(message, pkT...)
+	message = append(message, s.label...)
+	return s.KDF.Hash(message)
+}
+
+func (s hybridScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*hybridPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, nil, keyMismatch(s.kemID, pkR)
+	}
+
+	ssPQ, ctPQ, err := s.pq.Encap(rand, raw.pkPQ)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	skE, _, err := s.group.GenerateKeyPair(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.encap(raw, ssPQ, ctPQ, skE)
+}
+
+// EncapDeterministic splits ikmE into the encapsulation randomness of the PQ
+// KEM followed by candidates for the ephemeral private key of the group, of
+// which the first valid one is used, as in RandomScalar of draft-ietf-hpke-pq.
+// EncapSeedSize only counts one candidate; the test vectors of the draft carry
+// three for P-256.
+func (s hybridScheme) EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*hybridPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, nil, keyMismatch(s.kemID, pkR)
+	}
+
+	pq, ok := s.pq.(DeterministicKEMScheme)
+	if !ok {
+		return nil, nil, fmt.Errorf("KEM %04x does not support deterministic encapsulation", s.pq.ID())
+	}
+
+	NseedPQ := pq.EncapSeedSize()
+	Nsk := s.group.PrivateKeySize()
+	if len(ikmE) < s.EncapSeedSize() || (len(ikmE)-NseedPQ)%Nsk != 0 {
+		return nil, nil, fmt.Errorf("Invalid encapsulation seed size: got %d, expected %d plus a multiple of %d", len(ikmE), NseedPQ, Nsk)
+	}
+
+	ssPQ, ctPQ, err := pq.EncapDeterministic(ikmE[:NseedPQ], raw.pkPQ)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var skE KEMPrivateKey
+	for candidates := ikmE[NseedPQ:]; skE == nil; candidates = candidates[Nsk:] {
+		if len(candidates) == 0 {
+			return nil, nil, fmt.Errorf("No valid ephemeral private key in the encapsulation seed")
+		}
+		skE, _ = s.group.UnmarshalPrivate(candidates[:Nsk])
+	}
+
+	return s.encap(raw, ssPQ, ctPQ, skE)
+}
+
+// EncapSeedSize returns zero if the PQ KEM cannot be derandomized.
+func (s hybridScheme) EncapSeedSize() int {
+	pq, ok := s.pq.(DeterministicKEMScheme)
+	if !ok {
+		return 0
+	}
+	return pq.EncapSeedSize() + s.group.PrivateKeySize()
+}
+
+func (s hybridScheme) encap(raw *hybridPublicKey, ssPQ, ctPQ []byte, skE KEMPrivateKey) ([]byte, []byte, error) {
+	ssT, err := s.group.DH(skE, raw.pkT)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctT, err := s.group.Marshal(skE.PublicKey())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pkTm, err := s.group.Marshal(raw.pkT)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedSecret := s.combiner(ssPQ, ssT, ctT, pkTm)
+
+	enc := make([]byte, len(ctPQ)+len(ctT))
+	copy(enc, ctPQ)
+	copy(enc[len(ctPQ):], ctT)
+	return sharedSecret, enc, nil
+}
+
+func (s hybridScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw, ok := skR.(*hybridPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, skR)
+	}
+
+	// The traditional encapsulation is the trailing public key
+	NctT := s.group.PublicKeySize()
+	if len(enc) <= NctT {
+		return nil, fmt.Errorf("%w: got %d bytes of encapsulated key", ErrInvalidKEMPublicKey, len(enc))
+	}
+	ctPQ, ctT := enc[:len(enc)-NctT], enc[len(enc)-NctT:]
+
+	ssPQ, err := s.pq.Decap(ctPQ, raw.skPQ)
+	if err != nil {
+		return nil, err
+	}
+
+	pkE, err := s.group.Unmarshal(ctT)
+	if err != nil {
+		return nil, err
+	}
+
+	ssT, err := s.group.DH(raw.skT, pkE)
+	if err != nil {
+		return nil, err
+	}
+
+	pkTm, err := s.group.Marshal(raw.pub.pkT)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.combiner(ssPQ, ssT, ctT, pkTm), nil
+}
+
+func (s hybridScheme) PublicKeySize() int {
+	return s.pq.PublicKeySize() + s.group.PublicKeySize()
+}
+
+func (s hybridScheme) PrivateKeySize() int {
+	return 32
+}
+
+////////////////////
+// Sender signatures
+
+// signSender signs msg for SetupSignedS. ECDSA signs a digest of msg with
+// the hash matching the curve; the other schemes sign msg itself.
+func signSender(rand io.Reader, signer crypto.Signer, msg []byte) ([]byte, error) {
+	if signer == nil {
+		return nil, fmt.Errorf("No signer")
+	}
+
+	if pub, ok := signer.Public().(*ecdsa.PublicKey); ok {
+		hash, err := ecdsaHash(pub)
+		if err != nil {
+			return nil, err
+		}
+
+		h := hash.New()
+		h.Write(msg)
+		return signer.Sign(rand, h.Sum(nil), hash)
+	}
+
+	return signer.Sign(rand, msg, crypto.Hash(0))
+}
+
+// verifySender verifies a signature made by signSender. It only fails with an
+// error if pub is of an unsupported type.
+func verifySender(pub crypto.PublicKey, msg, sig []byte) (bool, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if len(pub) != ed25519.PublicKeySize {
+			return false, nil
+		}
+		return ed25519.Verify(pub, msg, sig), nil
+	case *ecdsa.PublicKey:
+		hash, err := ecdsaHash(pub)
+		if err != nil {
+			return false, err
+		}
+
+		h := hash.New()
+		h.Write(msg)
+		return ecdsa.VerifyASN1(pub, h.Sum(nil), sig), nil
+	case circlsign.PublicKey:
+		return pub.Scheme().Verify(pub, msg, sig, nil), nil
+	}
+	return false, fmt.Errorf("Unsupported sender public key: %T", pub)
+}
+
+func ecdsaHash(pub *ecdsa.PublicKey) (crypto.Hash, error) {
+	switch pub.Curve {
+	case elliptic.P256():
+		return crypto.SHA256, nil
+	case elliptic.P384():
+		return crypto.SHA384, nil
+	case elliptic.P521():
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("Unsupported ECDSA curve: %v", pub.Curve)
+}
+
+//////////
+// AES-GCM
+
+type aesgcmScheme struct {
+	keySize int
 }
 
 func (s aesgcmScheme) ID() AEADID {
@@ -653,8 +2276,7 @@ func (s aesgcmScheme) ID() AEADID {
 	case 32:
 		return AEAD_AESGCM256
 	}
-	panic(fmt.Sprintf("Unsupported key size: %d", s.keySize))
-
+	return 0
 }
 
 func (s aesgcmScheme) New(key []byte) (cipher.AEAD, error) {
@@ -678,6 +2300,27 @@ func (s aesgcmScheme) NonceSize() int {
 	return 12
 }
 
+//////////
+// Export-only
+
+type exportOnlyScheme struct{}
+
+func (s exportOnlyScheme) ID() AEADID {
+	return AEAD_EXPORT_ONLY
+}
+
+func (s exportOnlyScheme) New(key []byte) (cipher.AEAD, error) {
+	return nil, ErrExportOnly
+}
+
+func (s exportOnlyScheme) KeySize() int {
+	return 0
+}
+
+func (s exportOnlyScheme) NonceSize() int {
+	return 0
+}
+
 //////////
 // ChaCha20-Poly1305
 
@@ -700,6 +2343,46 @@ func (s chachaPolyScheme) NonceSize() int {
 	return chacha20poly1305.NonceSize
 }
 
+//////////
+// SM4-GCM and SM4-CCM
+
+// sm4Scheme is the SM4 block cipher of GB/T 32907-2016 in GCM or CCM mode,
+// with the 12-byte nonces and 16-byte tags of RFC 8998.
+type sm4Scheme struct {
+	ccm bool
+}
+
+func (s sm4Scheme) ID() AEADID {
+	if s.ccm {
+		return AEAD_SM4CCM
+	}
+	return AEAD_SM4GCM
+}
+
+func (s sm4Scheme) New(key []byte) (cipher.AEAD, error) {
+	if len(key) != sm4.BlockSize {
+		return nil, fmt.Errorf("Incorrect key size %d != %d", len(key), sm4.BlockSize)
+	}
+
+	block, err := sm4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.ccm {
+		return newCCM(block)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s sm4Scheme) KeySize() int {
+	return sm4.BlockSize
+}
+
+func (s sm4Scheme) NonceSize() int {
+	return 12
+}
+
 ///////
 // HKDF
 
@@ -718,7 +2401,7 @@ func (s hkdfScheme) ID() KDFID {
 	case crypto.SHA3_256:
 		return KDF_HKDF_SHA3_256
 	}
-	panic(fmt.Sprintf("Unsupported hash: %d", s.hash))
+	return 0
 }
 
 func (s hkdfScheme) Hash(message []byte) []byte {
@@ -728,19 +2411,48 @@ func (s hkdfScheme) Hash(message []byte) []byte {
 }
 
 func (s hkdfScheme) Extract(salt, ikm []byte) []byte {
+	return hkdfExtract(s.hash.New, salt, ikm)
+}
+
+func (s hkdfScheme) Expand(prk, info []byte, outLen int) []byte {
+	return hkdfExpand(s.hash.New, prk, info, outLen)
+}
+
+func (s hkdfScheme) LabeledExtract(salt []byte, suiteID []byte, label string, ikm []byte) []byte {
+	return s.Extract(salt, labeledIKM(suiteID, label, ikm))
+}
+
+// LabeledExpand fails with ErrExportLengthTooLarge if L is more than 255
+// times the hash size, the most HKDF-Expand can produce and always few enough
+// to encode on two bytes.
+func (s hkdfScheme) LabeledExpand(prk []byte, suiteID []byte, label string, info []byte, L int) ([]byte, error) {
+	if err := checkExportLength(s, L); err != nil {
+		return nil, err
+	}
+
+	return s.Expand(prk, labeledInfo(suiteID, label, info, L), L), nil
+}
+
+func (s hkdfScheme) OutputSize() int {
+	return s.hash.Size()
+}
+
+// hkdfExtract and hkdfExpand implement HKDF over any hash function, so that
+// KDFs whose hash is not a crypto.Hash, such as HKDF-SM3, can share them.
+func hkdfExtract(newHash func() hash.Hash, salt, ikm []byte) []byte {
 	saltOrZero := salt
 
 	// if [salt is] not provided, it is set to a string of HashLen zeros
 	if salt == nil {
-		saltOrZero = make([]byte, s.hash.Size())
+		saltOrZero = make([]byte, newHash().Size())
 	}
 
-	h := hmac.New(s.hash.New, saltOrZero)
+	h := hmac.New(newHash, saltOrZero)
 	h.Write(ikm)
 	return h.Sum(nil)
 }
 
-func (s hkdfScheme) Expand(prk, info []byte, outLen int) []byte {
+func hkdfExpand(newHash func() hash.Hash, prk, info []byte, outLen int) []byte {
 	out := []byte{}
 	T := []byte{}
 	i := byte(1)
@@ -748,7 +2460,7 @@ func (s hkdfScheme) Expand(prk, info []byte, outLen int) []byte {
 		block := append(T, info...)
 		block = append(block, i)
 
-		h := hmac.New(s.hash.New, prk)
+		h := hmac.New(newHash, prk)
 		h.Write(block)
 
 		T = h.Sum(nil)
@@ -758,25 +2470,60 @@ func (s hkdfScheme) Expand(prk, info []byte, outLen int) []byte {
 	return out[:outLen]
 }
 
-func (s hkdfScheme) LabeledExtract(salt []byte, label string, ikm []byte) []byte {
-	labeledIKM := append([]byte(rfcLabel+" "+label), ikm...)
-	return s.Extract(salt, labeledIKM)
+// labeledIKM and labeledInfo build the inputs of LabeledExtract and
+// LabeledExpand, as in RFC 9180, Section 4.
+func labeledIKM(suiteID []byte, label string, ikm []byte) []byte {
+	labeledIKM := append([]byte(rfcLabel), suiteID...)
+	labeledIKM = append(labeledIKM, []byte(label)...)
+	return append(labeledIKM, ikm...)
+}
+
+func labeledInfo(suiteID []byte, label string, info []byte, L int) []byte {
+	labeledInfo := make([]byte, 2)
+	binary.BigEndian.PutUint16(labeledInfo, uint16(L))
+	labeledInfo = append(labeledInfo, []byte(rfcLabel)...)
+	labeledInfo = append(labeledInfo, suiteID...)
+	labeledInfo = append(labeledInfo, []byte(label)...)
+	return append(labeledInfo, info...)
+}
+
+///////////
+// HKDF-SM3
+
+// hkdfSM3Scheme is HKDF with the SM3 hash function of GB/T 32905-2016, which
+// has no crypto.Hash value of its own.
+type hkdfSM3Scheme struct{}
+
+func (s hkdfSM3Scheme) ID() KDFID {
+	return KDF_HKDF_SM3
+}
+
+func (s hkdfSM3Scheme) Hash(message []byte) []byte {
+	return sm3.Sm3Sum(message)
 }
 
-func (s hkdfScheme) LabeledExpand(prk []byte, label string, info []byte, L int) []byte {
-	if L > (1 << 16) {
-		panic("Expand length cannot be larger than 2^16")
+func (s hkdfSM3Scheme) Extract(salt, ikm []byte) []byte {
+	return hkdfExtract(sm3.New, salt, ikm)
+}
+
+func (s hkdfSM3Scheme) Expand(prk, info []byte, outLen int) []byte {
+	return hkdfExpand(sm3.New, prk, info, outLen)
+}
+
+func (s hkdfSM3Scheme) LabeledExtract(salt []byte, suiteID []byte, label string, ikm []byte) []byte {
+	return s.Extract(salt, labeledIKM(suiteID, label, ikm))
+}
+
+func (s hkdfSM3Scheme) LabeledExpand(prk []byte, suiteID []byte, label string, info []byte, L int) ([]byte, error) {
+	if err := checkExportLength(s, L); err != nil {
+		return nil, err
 	}
 
-	lengthBuffer := make([]byte, 2)
-	binary.BigEndian.PutUint16(lengthBuffer, uint16(L))
-	labeledLength := append(lengthBuffer, []byte(rfcLabel+" "+label)...)
-	labeledInfo := append(labeledLength, info...)
-	return s.Expand(prk, labeledInfo, L)
+	return s.Expand(prk, labeledInfo(suiteID, label, info, L), L), nil
 }
 
-func (s hkdfScheme) OutputSize() int {
-	return s.hash.Size()
+func (s hkdfSM3Scheme) OutputSize() int {
+	return 32
 }
 
 ///////////////////////////
@@ -785,42 +2532,160 @@ func (s hkdfScheme) OutputSize() int {
 type KEMID uint16
 
 const (
-	DHKEM_P256   KEMID = 0x0010
-	DHKEM_P521   KEMID = 0x0012
-	DHKEM_X25519 KEMID = 0x0020
-	DHKEM_X448   KEMID = 0x0021
-	KEM_SIKE503  KEMID = 0xFFFE
-	KEM_SIKE751  KEMID = 0xFFFF
+	DHKEM_P256                 KEMID = 0x0010
+	DHKEM_P384                 KEMID = 0x0011
+	DHKEM_P521                 KEMID = 0x0012
+	DHKEM_X25519               KEMID = 0x0020
+	DHKEM_X448                 KEMID = 0x0021
+	KEM_MLKEM768               KEMID = 0x0041
+	KEM_MLKEM1024              KEMID = 0x0042
+	KEM_MLKEM768_P256          KEMID = 0x0050
+	KEM_MLKEM1024_P384         KEMID = 0x0051
+	KEM_XWING                  KEMID = 0x647A
+	DHKEM_SM2                  KEMID = 0xFF70
+	DHKEM_SECP256K1            KEMID = 0xFF71
+	DHKEM_SECP256K1_COMPRESSED KEMID = 0xFF72
+	DHKEM_BRAINPOOLP256R1      KEMID = 0xFF73
+	DHKEM_BRAINPOOLP384R1      KEMID = 0xFF74
+	DHKEM_BRAINPOOLP512R1      KEMID = 0xFF75
+	KEM_RSA2048                KEMID = 0xFF80
+	KEM_RSA3072                KEMID = 0xFF81
+	KEM_RSA4096                KEMID = 0xFF82
+	KEM_FRODO640SHAKE          KEMID = 0xFF90
+	KEM_SIKE503                KEMID = 0xFFFE
+	KEM_SIKE751                KEMID = 0xFFFF
 )
 
 var kems = map[KEMID]KEMScheme{
-	DHKEM_X25519: &dhkemScheme{group: x25519Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}},
-	DHKEM_X448:   &dhkemScheme{group: x448Scheme{}, KDF: hkdfScheme{hash: crypto.SHA512}},
-	DHKEM_P256:   &dhkemScheme{group: ecdhScheme{curve: elliptic.P256()}, KDF: hkdfScheme{hash: crypto.SHA256}},
-	DHKEM_P521:   &dhkemScheme{group: ecdhScheme{curve: elliptic.P521()}, KDF: hkdfScheme{hash: crypto.SHA512}},
-	KEM_SIKE503:  &sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}},
-	KEM_SIKE751:  &sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}},
-}
-
-func newKEMScheme(kemID KEMID) (KEMScheme, bool) {
+	DHKEM_X25519:               &dhkemScheme{group: x25519Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}},
+	DHKEM_X448:                 &dhkemScheme{group: x448Scheme{}, KDF: hkdfScheme{hash: crypto.SHA512}},
+	DHKEM_P256:                 &dhkemScheme{group: ecdhScheme{curve: ecdh.P256()}, KDF: hkdfScheme{hash: crypto.SHA256}},
+	DHKEM_P384:                 &dhkemScheme{group: ecdhScheme{curve: ecdh.P384()}, KDF: hkdfScheme{hash: crypto.SHA384}},
+	DHKEM_P521:                 &dhkemScheme{group: ecdhScheme{curve: ecdh.P521()}, KDF: hkdfScheme{hash: crypto.SHA512}},
+	KEM_MLKEM768:               &mlkemScheme{kemID: KEM_MLKEM768},
+	KEM_MLKEM1024:              &mlkemScheme{kemID: KEM_MLKEM1024},
+	KEM_XWING:                  &xwingScheme{pq: mlkemScheme{kemID: KEM_MLKEM768}},
+	KEM_MLKEM768_P256:          &hybridScheme{kemID: KEM_MLKEM768_P256, label: "MLKEM768-P256", group: ecdhScheme{curve: ecdh.P256()}, pq: &mlkemScheme{kemID: KEM_MLKEM768}, KDF: hkdfScheme{hash: crypto.SHA3_256}},
+	KEM_MLKEM1024_P384:         &hybridScheme{kemID: KEM_MLKEM1024_P384, label: "MLKEM1024-P384", group: ecdhScheme{curve: ecdh.P384()}, pq: &mlkemScheme{kemID: KEM_MLKEM1024}, KDF: hkdfScheme{hash: crypto.SHA3_256}},
+	DHKEM_SM2:                  &dhkemScheme{group: ellipticScheme{kemID: DHKEM_SM2, curve: sm2P256}, KDF: hkdfSM3Scheme{}},
+	DHKEM_SECP256K1:            &dhkemScheme{group: secp256k1Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}},
+	DHKEM_SECP256K1_COMPRESSED: &dhkemScheme{group: secp256k1Scheme{compressed: true}, KDF: hkdfScheme{hash: crypto.SHA256}},
+	DHKEM_BRAINPOOLP256R1:      &dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP256R1, curve: brainpoolP256r1}, KDF: hkdfScheme{hash: crypto.SHA256}},
+	DHKEM_BRAINPOOLP384R1:      &dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP384R1, curve: brainpoolP384r1}, KDF: hkdfScheme{hash: crypto.SHA384}},
+	DHKEM_BRAINPOOLP512R1:      &dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP512R1, curve: brainpoolP512r1}, KDF: hkdfScheme{hash: crypto.SHA512}},
+	KEM_FRODO640SHAKE:          &circlKEMScheme{kemID: KEM_FRODO640SHAKE, scheme: frodo640shake.Scheme()},
+	KEM_RSA2048:                &rsaKEMScheme{kemID: KEM_RSA2048, bits: 2048, KDF: hkdfScheme{hash: crypto.SHA256}},
+	KEM_RSA3072:                &rsaKEMScheme{kemID: KEM_RSA3072, bits: 3072, KDF: hkdfScheme{hash: crypto.SHA256}},
+	KEM_RSA4096:                &rsaKEMScheme{kemID: KEM_RSA4096, bits: 4096, KDF: hkdfScheme{hash: crypto.SHA256}},
+}
+
+func newKEMScheme(kemID KEMID, version Version) (KEMScheme, bool) {
 	switch kemID {
 	case DHKEM_X25519:
-		return &dhkemScheme{group: x25519Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}}, true
+		return &dhkemScheme{group: x25519Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}, version: version}, true
 	case DHKEM_X448:
-		return &dhkemScheme{group: x448Scheme{}, KDF: hkdfScheme{hash: crypto.SHA512}}, true
+		return &dhkemScheme{group: x448Scheme{}, KDF: hkdfScheme{hash: crypto.SHA512}, version: version}, true
 	case DHKEM_P256:
-		return &dhkemScheme{group: ecdhScheme{curve: elliptic.P256()}, KDF: hkdfScheme{hash: crypto.SHA256}}, true
+		return &dhkemScheme{group: ecdhScheme{curve: ecdh.P256(), version: version}, KDF: hkdfScheme{hash: crypto.SHA256}, version: version}, true
+	case DHKEM_P384:
+		return &dhkemScheme{group: ecdhScheme{curve: ecdh.P384(), version: version}, KDF: hkdfScheme{hash: crypto.SHA384}, version: version}, true
 	case DHKEM_P521:
-		return &dhkemScheme{group: ecdhScheme{curve: elliptic.P521()}, KDF: hkdfScheme{hash: crypto.SHA512}}, true
-	case KEM_SIKE503:
-		return &sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}}, true
-	case KEM_SIKE751:
-		return &sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}}, true
+		return &dhkemScheme{group: ecdhScheme{curve: ecdh.P521(), version: version}, KDF: hkdfScheme{hash: crypto.SHA512}, version: version}, true
+	case KEM_MLKEM768:
+		return &mlkemScheme{kemID: KEM_MLKEM768}, true
+	case KEM_MLKEM1024:
+		return &mlkemScheme{kemID: KEM_MLKEM1024}, true
+	case KEM_XWING:
+		return &xwingScheme{pq: mlkemScheme{kemID: KEM_MLKEM768}}, true
+	case KEM_MLKEM768_P256:
+		return &hybridScheme{kemID: KEM_MLKEM768_P256, label: "MLKEM768-P256", group: ecdhScheme{curve: ecdh.P256()}, pq: &mlkemScheme{kemID: KEM_MLKEM768}, KDF: hkdfScheme{hash: crypto.SHA3_256}}, true
+	case KEM_MLKEM1024_P384:
+		return &hybridScheme{kemID: KEM_MLKEM1024_P384, label: "MLKEM1024-P384", group: ecdhScheme{curve: ecdh.P384()}, pq: &mlkemScheme{kemID: KEM_MLKEM1024}, KDF: hkdfScheme{hash: crypto.SHA3_256}}, true
+	case DHKEM_SM2:
+		return &dhkemScheme{group: ellipticScheme{kemID: DHKEM_SM2, curve: sm2P256}, KDF: hkdfSM3Scheme{}, version: version}, true
+	case DHKEM_SECP256K1:
+		return &dhkemScheme{group: secp256k1Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}, version: version}, true
+	case DHKEM_SECP256K1_COMPRESSED:
+		return &dhkemScheme{group: secp256k1Scheme{compressed: true}, KDF: hkdfScheme{hash: crypto.SHA256}, version: version}, true
+	case DHKEM_BRAINPOOLP256R1:
+		return &dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP256R1, curve: brainpoolP256r1}, KDF: hkdfScheme{hash: crypto.SHA256}, version: version}, true
+	case DHKEM_BRAINPOOLP384R1:
+		return &dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP384R1, curve: brainpoolP384r1}, KDF: hkdfScheme{hash: crypto.SHA384}, version: version}, true
+	case DHKEM_BRAINPOOLP512R1:
+		return &dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP512R1, curve: brainpoolP512r1}, KDF: hkdfScheme{hash: crypto.SHA512}, version: version}, true
+	case KEM_FRODO640SHAKE:
+		return &circlKEMScheme{kemID: KEM_FRODO640SHAKE, scheme: frodo640shake.Scheme()}, true
+	case KEM_RSA2048:
+		return &rsaKEMScheme{kemID: KEM_RSA2048, bits: 2048, KDF: hkdfScheme{hash: crypto.SHA256}}, true
+	case KEM_RSA3072:
+		return &rsaKEMScheme{kemID: KEM_RSA3072, bits: 3072, KDF: hkdfScheme{hash: crypto.SHA256}}, true
+	case KEM_RSA4096:
+		return &rsaKEMScheme{kemID: KEM_RSA4096, bits: 4096, KDF: hkdfScheme{hash: crypto.SHA256}}, true
 	default:
+		if newScheme, ok := withdrawnKEMSchemes[kemID]; ok {
+			return newScheme(), true
+		}
 		return nil, false
 	}
 }
 
+// withdrawnKEMs maps the identifiers of KEMs that are no longer secure to the
+// reason for their withdrawal. Their implementations are only compiled in
+// with an opt-in build tag, which registers them in withdrawnKEMSchemes.
+var withdrawnKEMs = map[KEMID]string{
+	KEM_SIKE503: "SIKE is broken by the Castryck-Decru key recovery attack; build with -tags hpke_sike to decrypt legacy data",
+	KEM_SIKE751: "SIKE is broken by the Castryck-Decru key recovery attack; build with -tags hpke_sike to decrypt legacy data",
+}
+
+var withdrawnKEMSchemes = map[KEMID]func() KEMScheme{}
+
+// hybridKEMParams describes a registered hybrid KEM in terms of the
+// identifiers of its components.
+type hybridKEMParams struct {
+	label   string
+	dhkemID KEMID
+	pqKEMID KEMID
+	kdfID   KDFID
+}
+
+func (p hybridKEMParams) scheme(kemID KEMID) (*hybridScheme, error) {
+	dhkem, ok := lookupKEM(p.dhkemID, VersionRFC9180)
+	if !ok {
+		return nil, fmt.Errorf("Unknown KEM id: %04x", p.dhkemID)
+	}
+
+	group, ok := dhkem.(*dhkemScheme)
+	if !ok {
+		return nil, fmt.Errorf("KEM %04x is not a DHKEM", p.dhkemID)
+	}
+
+	pq, ok := lookupKEM(p.pqKEMID, VersionRFC9180)
+	if !ok {
+		return nil, fmt.Errorf("Unknown KEM id: %04x", p.pqKEMID)
+	}
+
+	kdf, ok := lookupKDF(p.kdfID)
+	if !ok {
+		return nil, fmt.Errorf("Unknown KDF id: %04x", p.kdfID)
+	}
+
+	return &hybridScheme{kemID: kemID, label: p.label, group: group.group, pq: pq, KDF: kdf}, nil
+}
+
+// RegisterHybridKEM makes a hybrid of the DH group underlying the DHKEM
+// dhkemID and the KEM pqKEMID available under kemID, so that
+// AssembleCipherSuite can build ciphersuites from it. The shared secrets are
+// combined with the hash function of the KDF kdfID, domain-separated by label.
+func RegisterHybridKEM(kemID KEMID, label string, dhkemID, pqKEMID KEMID, kdfID KDFID) error {
+	params := hybridKEMParams{label, dhkemID, pqKEMID, kdfID}
+	scheme, err := params.scheme(kemID)
+	if err != nil {
+		return err
+	}
+
+	return RegisterKEM(kemID, func() KEMScheme { return scheme })
+}
+
 ///////////////////////////
 // Pre-defined KDF identifiers
 
@@ -831,6 +2696,7 @@ const (
 	KDF_HKDF_SHA384   KDFID = 0x0002
 	KDF_HKDF_SHA512   KDFID = 0x0003
 	KDF_HKDF_SHA3_256 KDFID = 0x0004
+	KDF_HKDF_SM3      KDFID = 0xFF70
 )
 
 var kdfs = map[KDFID]KDFScheme{
@@ -838,6 +2704,7 @@ var kdfs = map[KDFID]KDFScheme{
 	KDF_HKDF_SHA384:   hkdfScheme{hash: crypto.SHA384},
 	KDF_HKDF_SHA512:   hkdfScheme{hash: crypto.SHA512},
 	KDF_HKDF_SHA3_256: hkdfScheme{hash: crypto.SHA3_256},
+	KDF_HKDF_SM3:      hkdfSM3Scheme{},
 }
 
 ///////////////////////////
@@ -849,33 +2716,189 @@ const (
 	AEAD_AESGCM128        AEADID = 0x0001
 	AEAD_AESGCM256        AEADID = 0x0002
 	AEAD_CHACHA20POLY1305 AEADID = 0x0003
+	AEAD_SM4GCM           AEADID = 0xFF70
+	AEAD_SM4CCM           AEADID = 0xFF71
+	AEAD_EXPORT_ONLY      AEADID = 0xFFFF
 )
 
 var aeads = map[AEADID]AEADScheme{
 	AEAD_AESGCM128:        aesgcmScheme{keySize: 16},
 	AEAD_AESGCM256:        aesgcmScheme{keySize: 32},
 	AEAD_CHACHA20POLY1305: chachaPolyScheme{},
+	AEAD_SM4GCM:           sm4Scheme{},
+	AEAD_SM4CCM:           sm4Scheme{ccm: true},
+	AEAD_EXPORT_ONLY:      exportOnlyScheme{},
+}
+
+/////////////////////
+// Algorithm registry
+
+// Algorithms registered at run time, in addition to the pre-defined ones.
+// Each factory is called whenever a ciphersuite is assembled.
+var (
+	registryMutex   sync.RWMutex
+	registeredKEMs  = map[KEMID]func() KEMScheme{}
+	registeredKDFs  = map[KDFID]func() KDFScheme{}
+	registeredAEADs = map[AEADID]func() AEADScheme{}
+)
+
+// RegisterKEM makes the KEM returned by factory available under kemID, so
+// that AssembleCipherSuite can build RFC 9180 ciphersuites from it. A DHKEM
+// over a custom group can be registered with a factory returning NewDHKEM.
+// It is an error to register an identifier that is pre-defined or already
+// registered, or a factory whose KEM has a different identifier.
+func RegisterKEM(kemID KEMID, factory func() KEMScheme) error {
+	if _, withdrawn := withdrawnKEMs[kemID]; withdrawn {
+		return fmt.Errorf("KEM id already in use: %04x", kemID)
+	}
+	if _, ok := newKEMScheme(kemID, VersionRFC9180); ok {
+		return fmt.Errorf("KEM id already in use: %04x", kemID)
+	}
+
+	kem := factory()
+	if kem == nil || kem.ID() != kemID {
+		return fmt.Errorf("KEM factory does not return a KEM with id %04x", kemID)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registeredKEMs[kemID]; ok {
+		return fmt.Errorf("KEM id already in use: %04x", kemID)
+	}
+
+	registeredKEMs[kemID] = factory
+	return nil
+}
+
+// RegisterKDF makes the KDF returned by factory available under kdfID. It is
+// an error to register an identifier that is pre-defined or already
+// registered, or a factory whose KDF has a different identifier.
+func RegisterKDF(kdfID KDFID, factory func() KDFScheme) error {
+	if _, ok := kdfs[kdfID]; ok {
+		return fmt.Errorf("KDF id already in use: %04x", kdfID)
+	}
+
+	kdf := factory()
+	if kdf == nil || kdf.ID() != kdfID {
+		return fmt.Errorf("KDF factory does not return a KDF with id %04x", kdfID)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registeredKDFs[kdfID]; ok {
+		return fmt.Errorf("KDF id already in use: %04x", kdfID)
+	}
+
+	registeredKDFs[kdfID] = factory
+	return nil
+}
+
+// RegisterAEAD makes the AEAD returned by factory available under aeadID. It
+// is an error to register an identifier that is pre-defined or already
+// registered, or a factory whose AEAD has a different identifier.
+func RegisterAEAD(aeadID AEADID, factory func() AEADScheme) error {
+	if _, ok := aeads[aeadID]; ok {
+		return fmt.Errorf("AEAD id already in use: %04x", aeadID)
+	}
+
+	aead := factory()
+	if aead == nil || aead.ID() != aeadID {
+		return fmt.Errorf("AEAD factory does not return an AEAD with id %04x", aeadID)
+	}
+
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registeredAEADs[aeadID]; ok {
+		return fmt.Errorf("AEAD id already in use: %04x", aeadID)
+	}
+
+	registeredAEADs[aeadID] = factory
+	return nil
+}
+
+// lookupKEM resolves kemID to a pre-defined KEM, falling back to the
+// registry. Registered KEMs only speak RFC 9180.
+func lookupKEM(kemID KEMID, version Version) (KEMScheme, bool) {
+	if kem, ok := newKEMScheme(kemID, version); ok {
+		return kem, true
+	}
+
+	if version != VersionRFC9180 {
+		return nil, false
+	}
+
+	registryMutex.RLock()
+	factory, ok := registeredKEMs[kemID]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+func lookupKDF(kdfID KDFID) (KDFScheme, bool) {
+	if kdf, ok := kdfs[kdfID]; ok {
+		return kdf, true
+	}
+
+	registryMutex.RLock()
+	factory, ok := registeredKDFs[kdfID]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+func lookupAEAD(aeadID AEADID) (AEADScheme, bool) {
+	if aead, ok := aeads[aeadID]; ok {
+		return aead, true
+	}
+
+	registryMutex.RLock()
+	factory, ok := registeredAEADs[aeadID]
+	registryMutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
 }
 
+// AssembleCipherSuite returns the RFC 9180 ciphersuite with the given
+// algorithm identifiers.
 func AssembleCipherSuite(kemID KEMID, kdfID KDFID, aeadID AEADID) (CipherSuite, error) {
-	kem, ok := newKEMScheme(kemID)
+	return AssembleVersionedCipherSuite(VersionRFC9180, kemID, kdfID, aeadID)
+}
+
+// AssembleVersionedCipherSuite returns the ciphersuite with the given
+// algorithm identifiers speaking the given protocol version.
+func AssembleVersionedCipherSuite(version Version, kemID KEMID, kdfID KDFID, aeadID AEADID) (CipherSuite, error) {
+	if version != VersionRFC9180 && version != VersionDraft {
+		return CipherSuite{}, fmt.Errorf("Unknown version")
+	}
+
+	kem, ok := lookupKEM(kemID, version)
+	if reason, withdrawn := withdrawnKEMs[kemID]; !ok && withdrawn {
+		return CipherSuite{}, fmt.Errorf("%w: %04x: %s", ErrKEMWithdrawn, kemID, reason)
+	}
 	if !ok {
 		return CipherSuite{}, fmt.Errorf("Unknown KEM id")
 	}
 
-	kdf, ok := kdfs[kdfID]
+	kdf, ok := lookupKDF(kdfID)
 	if !ok {
 		return CipherSuite{}, fmt.Errorf("Unknown KDF id")
 	}
 
-	aead, ok := aeads[aeadID]
+	aead, ok := lookupAEAD(aeadID)
 	if !ok {
 		return CipherSuite{}, fmt.Errorf("Unknown AEAD id")
 	}
 
 	return CipherSuite{
-		KEM:  kem,
-		KDF:  kdf,
-		AEAD: aead,
+		KEM:     kem,
+		KDF:     kdf,
+		AEAD:    aead,
+		Version: version,
 	}, nil
 }
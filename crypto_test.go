@@ -3,15 +3,52 @@ package hpke
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdh"
 	"crypto/elliptic"
+	"crypto/mlkem"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"math/big"
+	"os"
+	"strings"
 	"testing"
 
-	"github.com/cloudflare/circl/dh/sidh"
+	"filippo.io/bigmod"
+	"github.com/cloudflare/circl/kem/frodo/frodo640shake"
+	dcrd "github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/sm3"
 )
 
+// mustMarshal and mustMarshalPrivate serialize keys of a KEM or a DH group,
+// which can only fail for keys that belong to another one.
+func mustMarshal(t testing.TB, s interface {
+	Marshal(pk KEMPublicKey) ([]byte, error)
+}, pk KEMPublicKey) []byte {
+	enc, err := s.Marshal(pk)
+	if err != nil {
+		t.Fatalf("Error marshaling public key: %v", err)
+	}
+	return enc
+}
+
+func mustMarshalPrivate(t testing.TB, s interface {
+	MarshalPrivate(sk KEMPrivateKey) ([]byte, error)
+}, sk KEMPrivateKey) []byte {
+	enc, err := s.MarshalPrivate(sk)
+	if err != nil {
+		t.Fatalf("Error marshaling private key: %v", err)
+	}
+	return enc
+}
+
 func randomBytes(size int) []byte {
 	out := make([]byte, size)
 	rand.Read(out)
@@ -23,11 +60,20 @@ func TestKEMSchemes(t *testing.T) {
 	schemes := []KEMScheme{
 		&dhkemScheme{group: x25519Scheme{}, KDF: hkdfScheme{hash: crypto.SHA256}},
 		&dhkemScheme{group: x448Scheme{}, KDF: hkdfScheme{hash: crypto.SHA512}},
-		&dhkemScheme{group: ecdhScheme{curve: elliptic.P256()}, KDF: hkdfScheme{hash: crypto.SHA256}},
-		&dhkemScheme{group: ecdhScheme{curve: elliptic.P521()}, KDF: hkdfScheme{hash: crypto.SHA512}},
-		&dhkemScheme{group: ecdhScheme{curve: elliptic.P256()}, KDF: hkdfScheme{hash: crypto.SHA3_256}},
-		&sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}},
-		&sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}},
+		&dhkemScheme{group: ecdhScheme{curve: ecdh.P256()}, KDF: hkdfScheme{hash: crypto.SHA256}},
+		&dhkemScheme{group: ecdhScheme{curve: ecdh.P384()}, KDF: hkdfScheme{hash: crypto.SHA384}},
+		&dhkemScheme{group: ecdhScheme{curve: ecdh.P521()}, KDF: hkdfScheme{hash: crypto.SHA512}},
+		&dhkemScheme{group: ecdhScheme{curve: ecdh.P256()}, KDF: hkdfScheme{hash: crypto.SHA3_256}},
+		&mlkemScheme{kemID: KEM_MLKEM768},
+		&mlkemScheme{kemID: KEM_MLKEM1024},
+		&xwingScheme{pq: mlkemScheme{kemID: KEM_MLKEM768}},
+		&hybridScheme{kemID: KEM_MLKEM768_P256, label: "MLKEM768-P256", group: ecdhScheme{curve: ecdh.P256()}, pq: &mlkemScheme{kemID: KEM_MLKEM768}, KDF: hkdfScheme{hash: crypto.SHA3_256}},
+		&hybridScheme{kemID: 0xFF00, label: "MLKEM1024-P521", group: ecdhScheme{curve: ecdh.P521()}, pq: &mlkemScheme{kemID: KEM_MLKEM1024}, KDF: hkdfScheme{hash: crypto.SHA512}},
+		&hybridScheme{kemID: 0xFF01, label: "MLKEM1024-X448", group: x448Scheme{}, pq: &mlkemScheme{kemID: KEM_MLKEM1024}, KDF: hkdfScheme{hash: crypto.SHA3_256}},
+		&dhkemScheme{group: ellipticScheme{kemID: DHKEM_SM2, curve: sm2P256}, KDF: hkdfSM3Scheme{}},
+		&dhkemScheme{group: ellipticScheme{kemID: DHKEM_BRAINPOOLP256R1, curve: brainpoolP256r1}, KDF: hkdfScheme{hash: crypto.SHA256}},
+		&rsaKEMScheme{kemID: KEM_RSA2048, bits: 2048, KDF: hkdfScheme{hash: crypto.SHA256}},
+		&circlKEMScheme{kemID: KEM_FRODO640SHAKE, scheme: frodo640shake.Scheme()},
 	}
 
 	for i, s := range schemes {
@@ -43,8 +89,8 @@ func TestKEMSchemes(t *testing.T) {
 		log.Printf("Scheme %d: KEM key pair generated successfully", i)
 
 		// 输出公钥和私钥
-		log.Printf("Scheme %d: Public Key: %s", i, hex.EncodeToString(s.Marshal(pkR)))
-		log.Printf("Scheme %d: Private Key: %s", i, hex.EncodeToString(s.MarshalPrivate(skR)))
+		log.Printf("Scheme %d: Public Key: %s", i, hex.EncodeToString(mustMarshal(t, s, pkR)))
+		log.Printf("Scheme %d: Private Key: %s", i, hex.EncodeToString(mustMarshalPrivate(t, s, skR)))
 
 		// Encapsulation
 		log.Printf("Scheme %d: Performing KEM encapsulation", i)
@@ -73,11 +119,18 @@ func TestKEMSchemes(t *testing.T) {
 }
 
 func TestDHSchemes(t *testing.T) {
-	schemes := []dhScheme{
-		ecdhScheme{curve: elliptic.P256()},
-		ecdhScheme{curve: elliptic.P521()},
+	schemes := []DHGroup{
+		ecdhScheme{curve: ecdh.P256()},
+		ecdhScheme{curve: ecdh.P384()},
+		ecdhScheme{curve: ecdh.P521()},
 		x25519Scheme{},
 		x448Scheme{},
+		ellipticScheme{kemID: DHKEM_SM2, curve: sm2P256},
+		secp256k1Scheme{},
+		secp256k1Scheme{compressed: true},
+		ellipticScheme{kemID: DHKEM_BRAINPOOLP256R1, curve: brainpoolP256r1},
+		ellipticScheme{kemID: DHKEM_BRAINPOOLP384R1, curve: brainpoolP384r1},
+		ellipticScheme{kemID: DHKEM_BRAINPOOLP512R1, curve: brainpoolP512r1},
 	}
 
 	for i, s := range schemes {
@@ -91,7 +144,7 @@ func TestDHSchemes(t *testing.T) {
 			t.Fatalf("[%d] Error generating DH key pair: %v", i, err)
 		}
 
-		enc := s.Marshal(pkA)
+		enc := mustMarshal(t, s, pkA)
 		_, err = s.Unmarshal(enc)
 		if err != nil {
 			t.Fatalf("[%d] Error parsing DH public key: %v", i, err)
@@ -111,8 +164,468 @@ func TestDHSchemes(t *testing.T) {
 			t.Fatalf("[%d] Asymmetric DH results [%x] != [%x]", i, zzAB, zzBA)
 		}
 
-		if len(s.Marshal(pkA)) != len(s.Marshal(pkB)) {
-			t.Fatalf("[%d] Non-constant public key size [%x] != [%x]", i, len(s.Marshal(pkA)), len(s.Marshal(pkB)))
+		if len(mustMarshal(t, s, pkA)) != len(mustMarshal(t, s, pkB)) {
+			t.Fatalf("[%d] Non-constant public key size [%x] != [%x]", i, len(mustMarshal(t, s, pkA)), len(mustMarshal(t, s, pkB)))
+		}
+	}
+}
+
+func TestECDHPointValidation(t *testing.T) {
+	for _, curve := range []ecdh.Curve{ecdh.P256(), ecdh.P384(), ecdh.P521()} {
+		s := ecdhScheme{curve: curve}
+
+		skR, pkR, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating DH key pair: %v", s.ID(), err)
+		}
+
+		// The point at infinity, a point off the curve, and a truncated point
+		enc := mustMarshal(t, s, pkR)
+		offCurve := append([]byte{}, enc...)
+		offCurve[len(offCurve)-1] ^= 0x01
+		for _, invalid := range [][]byte{{0x00}, offCurve, enc[:len(enc)-1]} {
+			if _, err := s.Unmarshal(invalid); err == nil {
+				t.Fatalf("[%04x] Invalid public key accepted: %x", s.ID(), invalid)
+			}
+		}
+
+		// Scalars that are zero or not less than the order
+		zero := make([]byte, s.PrivateKeySize())
+		order := elliptic.P256().Params().N
+		switch curve {
+		case ecdh.P384():
+			order = elliptic.P384().Params().N
+		case ecdh.P521():
+			order = elliptic.P521().Params().N
+		}
+		for _, invalid := range [][]byte{zero, order.FillBytes(make([]byte, s.PrivateKeySize()))} {
+			if _, err := s.UnmarshalPrivate(invalid); err == nil {
+				t.Fatalf("[%04x] Invalid private key accepted: %x", s.ID(), invalid)
+			}
+		}
+
+		// A key on a different curve
+		other := ecdhScheme{curve: ecdh.P256()}
+		if curve == ecdh.P256() {
+			other = ecdhScheme{curve: ecdh.P384()}
+		}
+		_, pkO, err := other.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating DH key pair: %v", other.ID(), err)
+		}
+		if _, err := s.DH(skR, pkO); err == nil {
+			t.Fatalf("[%04x] DH succeeded with a key on another curve", s.ID())
+		}
+	}
+}
+
+func TestEllipticPointValidation(t *testing.T) {
+	for _, s := range []ellipticScheme{
+		{kemID: DHKEM_SM2, curve: sm2P256},
+		{kemID: DHKEM_BRAINPOOLP256R1, curve: brainpoolP256r1},
+		{kemID: DHKEM_BRAINPOOLP384R1, curve: brainpoolP384r1},
+		{kemID: DHKEM_BRAINPOOLP512R1, curve: brainpoolP512r1},
+	} {
+		skR, pkR, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating DH key pair: %v", s.ID(), err)
+		}
+
+		// The point at infinity, a point off the curve, and a truncated point
+		enc := mustMarshal(t, s, pkR)
+		offCurve := append([]byte{}, enc...)
+		offCurve[len(offCurve)-1] ^= 0x01
+		for _, invalid := range [][]byte{{0x00}, offCurve, enc[:len(enc)-1]} {
+			if _, err := s.Unmarshal(invalid); !errors.Is(err, ErrInvalidKEMPublicKey) {
+				t.Fatalf("[%04x] Invalid public key accepted: %x", s.ID(), invalid)
+			}
+		}
+
+		// Scalars that are zero or not less than the order
+		zero := make([]byte, s.PrivateKeySize())
+		order := s.curve.n.Nat().Bytes(s.curve.n)
+		for _, invalid := range [][]byte{zero, order} {
+			if _, err := s.UnmarshalPrivate(invalid); !errors.Is(err, ErrInvalidKEMPrivateKey) {
+				t.Fatalf("[%04x] Invalid private key accepted: %x", s.ID(), invalid)
+			}
+		}
+
+		if _, err := s.DH(skR, pkR); err != nil {
+			t.Fatalf("[%04x] Error performing DH operation: %v", s.ID(), err)
+		}
+
+		// The generic arithmetic of elliptic.CurveParams assumes a = -3, so
+		// the brainpool curves are checked against OpenSSL instead
+		if s.kemID != DHKEM_SM2 {
+			continue
+		}
+
+		// The DH output is the x-coordinate of the shared point, as computed
+		// by the generic arithmetic of elliptic.CurveParams
+		skE, pkE, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating DH key pair: %v", s.ID(), err)
+		}
+
+		dh, err := s.DH(skE, pkR)
+		if err != nil {
+			t.Fatalf("[%04x] Error performing DH operation: %v", s.ID(), err)
+		}
+
+		generic := genericCurve(sm2.P256Sm2())
+		x, y := elliptic.Unmarshal(generic, mustMarshal(t, s, pkR))
+		x, _ = generic.ScalarMult(x, y, mustMarshalPrivate(t, s, skE))
+		if !bytes.Equal(dh, x.FillBytes(make([]byte, len(dh)))) {
+			t.Fatalf("[%04x] Incorrect DH output [%x] != [%x]", s.ID(), dh, x.Bytes())
+		}
+
+		x, y = generic.ScalarBaseMult(mustMarshalPrivate(t, s, skE))
+		if !bytes.Equal(mustMarshal(t, s, pkE), elliptic.Marshal(generic, x, y)) {
+			t.Fatalf("[%04x] Incorrect public key [%x]", s.ID(), mustMarshal(t, s, pkE))
+		}
+	}
+}
+
+// checkWeierstrassCurve checks that the generator of curve is on the curve
+// and has order n, and that the complete addition formulas handle doubling,
+// the point at infinity and the sum of a point and its negation.
+func checkWeierstrassCurve(t *testing.T, curve *weierstrassCurve) {
+	one := make([]byte, curve.scalarSize())
+	one[len(one)-1] = 1
+	gEnc, err := curve.ScalarBaseMult(one)
+	if err != nil {
+		t.Fatalf("[%s] Error multiplying the generator: %v", curve.name, err)
+	}
+	g, err := curve.decodePoint(gEnc)
+	if err != nil {
+		t.Fatalf("[%s] Generator is not on the curve: %v", curve.name, err)
+	}
+
+	if q := curve.scalarMult(curve.n.Nat().Bytes(curve.n), g); q.z.IsZero() != 1 {
+		t.Fatalf("[%s] Generator does not have order n", curve.name)
+	}
+
+	minusOne := curve.n.Nat().SubOne(curve.n).Bytes(curve.n)
+	minusG, err := curve.encodePoint(curve.scalarMult(minusOne, g))
+	if err != nil {
+		t.Fatalf("[%s] Error multiplying the generator: %v", curve.name, err)
+	}
+	size := curve.coordinateSize()
+	negY := curve.sub(bigmod.NewNat().ExpandFor(curve.p), curve.gy).Bytes(curve.p)
+	if !bytes.Equal(minusG[1:1+size], gEnc[1:1+size]) || !bytes.Equal(minusG[1+size:], negY) {
+		t.Fatalf("[%s] (n-1)*G is not -G", curve.name)
+	}
+
+	neg, _ := curve.decodePoint(minusG)
+	if q := curve.addPoints(g, neg); q.z.IsZero() != 1 {
+		t.Fatalf("[%s] G + (-G) is not the point at infinity", curve.name)
+	}
+
+	two := make([]byte, curve.scalarSize())
+	two[len(two)-1] = 2
+	double, err := curve.encodePoint(curve.addPoints(g, g))
+	if err != nil {
+		t.Fatalf("[%s] Error doubling the generator: %v", curve.name, err)
+	}
+	if twoG, _ := curve.ScalarBaseMult(two); !bytes.Equal(double, twoG) {
+		t.Fatalf("[%s] Doubling and multiplying the generator disagree", curve.name)
+	}
+	if sum, _ := curve.encodePoint(curve.addPoints(curve.identity(), g)); !bytes.Equal(sum, gEnc) {
+		t.Fatalf("[%s] O + G is not G", curve.name)
+	}
+}
+
+// TestSM2Curve checks the constant-time SM2 arithmetic against the curve of
+// tjfoc/gmsm, including the scalars at the ends of the range.
+func TestSM2Curve(t *testing.T) {
+	checkWeierstrassCurve(t, sm2P256)
+
+	params := sm2.P256Sm2().Params()
+	if params.P.Cmp(new(big.Int).SetBytes(sm2P256.p.Nat().Bytes(sm2P256.p))) != 0 ||
+		params.N.Cmp(new(big.Int).SetBytes(sm2P256.n.Nat().Bytes(sm2P256.n))) != 0 ||
+		params.B.Cmp(new(big.Int).SetBytes(sm2P256.b.Bytes(sm2P256.p))) != 0 ||
+		params.Gx.Cmp(new(big.Int).SetBytes(sm2P256.gx.Bytes(sm2P256.p))) != 0 ||
+		params.Gy.Cmp(new(big.Int).SetBytes(sm2P256.gy.Bytes(sm2P256.p))) != 0 {
+		t.Fatalf("SM2 domain parameters differ from sm2.P256Sm2")
+	}
+
+	generic := genericCurve(sm2.P256Sm2())
+	size := sm2P256.scalarSize()
+	scalars := [][]byte{
+		big.NewInt(1).FillBytes(make([]byte, size)),
+		big.NewInt(2).FillBytes(make([]byte, size)),
+		big.NewInt(15).FillBytes(make([]byte, size)),
+		new(big.Int).Sub(params.N, big.NewInt(1)).FillBytes(make([]byte, size)),
+		new(big.Int).Sub(params.N, big.NewInt(2)).FillBytes(make([]byte, size)),
+	}
+	for i := 0; i < 8; i++ {
+		k := make([]byte, size)
+		if _, err := rand.Read(k); err != nil {
+			t.Fatalf("Error reading random scalar: %v", err)
+		}
+		if sm2P256.checkScalar(k) == nil {
+			scalars = append(scalars, k)
+		}
+	}
+
+	px, py := generic.ScalarBaseMult(scalars[len(scalars)-1])
+	point := elliptic.Marshal(generic, px, py)
+	for _, k := range scalars {
+		x, y := generic.ScalarBaseMult(k)
+		if enc, err := sm2P256.ScalarBaseMult(k); err != nil || !bytes.Equal(enc, elliptic.Marshal(generic, x, y)) {
+			t.Fatalf("Incorrect product of the generator by %x: %v", k, err)
+		}
+
+		x, y = elliptic.Unmarshal(generic, point)
+		x, y = generic.ScalarMult(x, y, k)
+		if enc, err := sm2P256.ScalarMult(k, point); err != nil || !bytes.Equal(enc, elliptic.Marshal(generic, x, y)) {
+			t.Fatalf("Incorrect product of a point by %x: %v", k, err)
+		}
+	}
+}
+
+// TestBrainpoolVectors checks the brainpool curves against ECDH shared
+// secrets computed by OpenSSL, and checks that their generators have the
+// stated order.
+func TestBrainpoolVectors(t *testing.T) {
+	for _, curve := range []*weierstrassCurve{brainpoolP256r1, brainpoolP384r1, brainpoolP512r1} {
+		checkWeierstrassCurve(t, curve)
+	}
+
+	encoded, err := os.ReadFile("testdata/brainpool-ecdh-vectors.json")
+	if err != nil {
+		t.Fatalf("Failed reading test vectors: %v", err)
+	}
+
+	var vectors []struct {
+		KEMID KEMID  `json:"kem_id"`
+		SK    string `json:"sk"`
+		PK    string `json:"pk"`
+		DH    string `json:"dh"`
+	}
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatalf("Failed parsing test vectors: %v", err)
+	}
+
+	for i, tv := range vectors {
+		decode := func(value string) []byte {
+			data, err := hex.DecodeString(value)
+			if err != nil {
+				t.Fatalf("[%d] Invalid hex value: %v", i, err)
+			}
+			return data
+		}
+
+		s := kems[tv.KEMID].(*dhkemScheme).group
+
+		sk, err := s.UnmarshalPrivate(decode(tv.SK))
+		if err != nil {
+			t.Fatalf("[%d] Error unmarshaling private key: %v", i, err)
+		}
+
+		pk, err := s.Unmarshal(decode(tv.PK))
+		if err != nil {
+			t.Fatalf("[%d] Error unmarshaling public key: %v", i, err)
+		}
+
+		dh, err := s.DH(sk, pk)
+		if err != nil {
+			t.Fatalf("[%d] Error performing DH operation: %v", i, err)
+		}
+		if !bytes.Equal(dh, decode(tv.DH)) {
+			t.Fatalf("[%d] Incorrect DH output [%x] != [%s]", i, dh, tv.DH)
+		}
+	}
+}
+
+func TestSecp256k1Encodings(t *testing.T) {
+	uncompressed := secp256k1Scheme{}
+	compressed := secp256k1Scheme{compressed: true}
+
+	// The private key 1 has the generator as its public key
+	one := make([]byte, 32)
+	one[31] = 1
+	g := "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	for _, s := range []secp256k1Scheme{uncompressed, compressed} {
+		sk, err := s.UnmarshalPrivate(one)
+		if err != nil {
+			t.Fatalf("[%04x] Error parsing private key: %v", s.ID(), err)
+		}
+
+		enc := mustMarshal(t, s, sk.PublicKey())
+		if len(enc) != s.PublicKeySize() || hex.EncodeToString(enc[1:33]) != g[2:] {
+			t.Fatalf("[%04x] Incorrect public key [%x]", s.ID(), enc)
+		}
+	}
+
+	sk, pk, err := uncompressed.GenerateKeyPair(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating DH key pair: %v", err)
+	}
+
+	// Either encoding is accepted, and re-encoded in the encoding of the KEM
+	pub := pk.(*secp256k1PublicKey).pub
+	for _, s := range []secp256k1Scheme{uncompressed, compressed} {
+		for _, enc := range [][]byte{pub.Bytes(), pub.CompressedBytes()} {
+			pkS, err := s.Unmarshal(enc)
+			if err != nil {
+				t.Fatalf("[%04x] Error parsing public key %x: %v", s.ID(), enc, err)
+			}
+
+			if len(mustMarshal(t, s, pkS)) != s.PublicKeySize() {
+				t.Fatalf("[%04x] Incorrect public key size %d", s.ID(), len(mustMarshal(t, s, pkS)))
+			}
+		}
+	}
+
+	// The point at infinity, a point off the curve, and a truncated point
+	enc := mustMarshal(t, uncompressed, pk)
+	offCurve := append([]byte{}, enc...)
+	offCurve[len(offCurve)-1] ^= 0x01
+	for _, invalid := range [][]byte{{0x00}, offCurve, enc[:len(enc)-1], pub.CompressedBytes()[1:]} {
+		if _, err := uncompressed.Unmarshal(invalid); !errors.Is(err, ErrInvalidKEMPublicKey) {
+			t.Fatalf("Invalid public key accepted: %x", invalid)
+		}
+	}
+
+	// Keys of one encoding cannot be used with the other
+	if _, err := compressed.DH(sk, pk); !errors.Is(err, ErrKeyMismatch) {
+		t.Fatalf("DH succeeded with a key of the other encoding: %v", err)
+	}
+}
+
+// TestSecp256k1Vectors cross-checks the generated secp256k1 test vectors with
+// the independent implementation of dcrd.
+func TestSecp256k1Vectors(t *testing.T) {
+	encoded, err := os.ReadFile("testdata/test-vectors-secp256k1.json")
+	if err != nil {
+		t.Fatalf("Failed reading test vectors: %v", err)
+	}
+
+	var vectors []map[string]interface{}
+	if err := json.Unmarshal(encoded, &vectors); err != nil {
+		t.Fatalf("Failed parsing test vectors: %v", err)
+	}
+
+	for i, tv := range vectors {
+		field := func(name string) []byte {
+			value, _ := tv[name].(string)
+			data, err := hex.DecodeString(value)
+			if err != nil {
+				t.Fatalf("[%d] Invalid hex value for %s: %v", i, name, err)
+			}
+			return data
+		}
+
+		kemID := KEMID(tv["kem_id"].(float64))
+		serialize := (*dcrd.PublicKey).SerializeUncompressed
+		if kemID == DHKEM_SECP256K1_COMPRESSED {
+			serialize = (*dcrd.PublicKey).SerializeCompressed
+		}
+
+		skE := dcrd.PrivKeyFromBytes(field("skEm"))
+		skR := dcrd.PrivKeyFromBytes(field("skRm"))
+		if !bytes.Equal(serialize(skE.PubKey()), field("pkEm")) || !bytes.Equal(serialize(skR.PubKey()), field("pkRm")) {
+			t.Fatalf("[%d] Incorrect public key", i)
+		}
+
+		kemContext := append(field("enc"), field("pkRm")...)
+		dh := dcrd.GenerateSharedSecret(skE, skR.PubKey())
+		if mode := HPKEMode(tv["mode"].(float64)); mode == ModeAuth || mode == ModeAuthPSK {
+			skS := dcrd.PrivKeyFromBytes(field("skSm"))
+			if !bytes.Equal(serialize(skS.PubKey()), field("pkSm")) {
+				t.Fatalf("[%d] Incorrect public key", i)
+			}
+
+			kemContext = append(kemContext, field("pkSm")...)
+			dh = append(dh, dcrd.GenerateSharedSecret(skS, skR.PubKey())...)
+		}
+
+		kem := kems[kemID].(*dhkemScheme)
+		sharedSecret, err := kem.extractAndExpand(dh, kemContext, kem.KDF.OutputSize())
+		if err != nil {
+			t.Fatalf("[%d] Error computing shared secret: %v", i, err)
+		}
+
+		if !bytes.Equal(sharedSecret, field("shared_secret")) {
+			t.Fatalf("[%d] Incorrect shared secret [%x] != [%x]", i, sharedSecret, field("shared_secret"))
+		}
+	}
+}
+
+// Low-order points of Curve25519 and Curve448 in canonical and non-canonical
+// encodings, as listed by libsodium and RFC 7748.
+var (
+	x25519LowOrderEncodings = []string{
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b800",
+		"5f9c95bca3508c24b1d0b1559c83ef5b04445cc4581c8e86d8224eddd09f1157",
+		"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"eeffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff7f",
+		"0000000000000000000000000000000000000000000000000000000000000080",
+		"0100000000000000000000000000000000000000000000000000000000000080",
+		"e0eb7a7c3b41b8ae1656e3faf19fc46ada098deb9c32b1fd866205165f49b880",
+		"5f9c95bca3508c24b1d0b1559c83ef5b04445cc4581c8e86d8224eddd09f11d7",
+		"ecffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"edffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"eeffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	}
+
+	x448LowOrderEncodings = []string{
+		"0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		"0100000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+		"fefffffffffffffffffffffffffffffffffffffffffffffffffffffffeffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"fffffffffffffffffffffffffffffffffffffffffffffffffffffffffeffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+		"00000000000000000000000000000000000000000000000000000000ffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
+	}
+)
+
+func TestLowOrderPoints(t *testing.T) {
+	lowOrder := map[KEMID][]string{
+		DHKEM_X25519: x25519LowOrderEncodings,
+		DHKEM_X448:   x448LowOrderEncodings,
+	}
+
+	for kemID, encodings := range lowOrder {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+		strict := RejectLowOrderPoints(suite)
+
+		skR, pkR, err := suite.KEM.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating KEM key pair: %v", kemID, err)
+		}
+
+		if _, err := strict.KEM.Unmarshal(mustMarshal(t, suite.KEM, pkR)); err != nil {
+			t.Fatalf("[%04x] Valid public key rejected: %v", kemID, err)
+		}
+
+		for _, encHex := range encodings {
+			enc, _ := hex.DecodeString(encHex)
+
+			_, err := strict.KEM.Unmarshal(enc)
+			if !errors.Is(err, ErrLowOrderPoint) {
+				t.Fatalf("[%04x] Low-order point %x accepted: %v", kemID, enc, err)
+			}
+
+			pkE, err := suite.KEM.Unmarshal(enc)
+			if err != nil {
+				t.Fatalf("[%04x] Error unmarshaling public key %x: %v", kemID, enc, err)
+			}
+
+			_, _, err = suite.KEM.Encap(rand.Reader, pkE)
+			if !errors.Is(err, ErrLowOrderPoint) {
+				t.Fatalf("[%04x] Encap to low-order point %x succeeded: %v", kemID, enc, err)
+			}
+
+			_, err = suite.KEM.Decap(enc, skR)
+			if !errors.Is(err, ErrLowOrderPoint) {
+				t.Fatalf("[%04x] Decap of low-order point %x succeeded: %v", kemID, enc, err)
+			}
 		}
 	}
 }
@@ -122,6 +635,8 @@ func TestAEADSchemes(t *testing.T) {
 		aesgcmScheme{keySize: 16},
 		aesgcmScheme{keySize: 32},
 		chachaPolyScheme{},
+		sm4Scheme{},
+		sm4Scheme{ccm: true},
 	}
 
 	for i, s := range schemes {
@@ -160,3 +675,795 @@ func TestAEADSchemes(t *testing.T) {
 		}
 	}
 }
+
+func TestKDFLabeledExpandLength(t *testing.T) {
+	suiteID := kemSuiteID(DHKEM_X25519)
+	for id, s := range kdfs {
+		prk := s.LabeledExtract(nil, suiteID, "prk", randomBytes(32))
+		Nh := s.OutputSize()
+
+		out, err := s.LabeledExpand(prk, suiteID, "out", nil, 255*Nh)
+		if err != nil || len(out) != 255*Nh {
+			t.Fatalf("[%04x] Error expanding the maximum length: %v", id, err)
+		}
+
+		if _, err := s.LabeledExpand(prk, suiteID, "out", nil, 255*Nh+1); !errors.Is(err, ErrExportLengthTooLarge) {
+			t.Fatalf("[%04x] Long expansion not reported: %v", id, err)
+		}
+
+		if _, err := s.LabeledExpand(prk, suiteID, "out", nil, -1); err == nil {
+			t.Fatalf("[%04x] Negative expansion length accepted", id)
+		}
+	}
+}
+
+// TestSMVectors checks SM3 against GB/T 32905-2016, Appendix A.1, and SM4-GCM
+// and SM4-CCM against RFC 8998, Appendix A.
+func TestSMVectors(t *testing.T) {
+	digest := hkdfSM3Scheme{}.Hash([]byte("abc"))
+	if hex.EncodeToString(digest) != "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0" {
+		t.Fatalf("Incorrect SM3 digest [%x]", digest)
+	}
+	if !bytes.Equal(digest, sm3.Sm3Sum([]byte("abc"))) {
+		t.Fatalf("HKDF-SM3 does not hash with SM3")
+	}
+
+	key, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	nonce, _ := hex.DecodeString("00001234567800000000abcd")
+	aad, _ := hex.DecodeString("feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	pt, _ := hex.DecodeString("aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbccccccccccccccccddddddddddddddddeeeeeeeeeeeeeeeeffffffffffffffffeeeeeeeeeeeeeeeeaaaaaaaaaaaaaaaa")
+
+	vectors := map[AEADScheme]string{
+		sm4Scheme{}: "17f399f08c67d5ee19d0dc9969c4bb7d5fd46fd3756489069157b282bb200735d82710ca5c22f0ccfa7cbf93d496ac15a56834cbcf98c397b4024a2691233b8d" +
+			"83de3541e4c2b58177e065a9bf7b62ec",
+		sm4Scheme{ccm: true}: "48af93501fa62adbcd414cce6034d895dda1bf8f132f042098661572e7483094fd12e518ce062c98acee28d95df4416bed31a2f04476c18bb40c84a74b97dc5b" +
+			"16842d4fa186f56ab33256971fa110f4",
+	}
+
+	for s, ctHex := range vectors {
+		aead, err := s.New(key)
+		if err != nil {
+			t.Fatalf("[%04x] Error instantiating AEAD: %v", s.ID(), err)
+		}
+
+		ct := aead.Seal(nil, nonce, pt, aad)
+		if hex.EncodeToString(ct) != ctHex {
+			t.Fatalf("[%04x] Incorrect ciphertext [%x] != [%s]", s.ID(), ct, ctHex)
+		}
+
+		ct[0] ^= 0x01
+		if _, err := aead.Open(nil, nonce, ct, aad); err == nil {
+			t.Fatalf("[%04x] Modified ciphertext decrypted", s.ID())
+		}
+	}
+
+	// EncryptContext.Seal rejects messages that SM4-CCM cannot encrypt
+	suite, err := AssembleCipherSuite(DHKEM_SM2, KDF_HKDF_SM3, AEAD_SM4CCM)
+	if err != nil {
+		t.Fatalf("Error assembling ciphersuite: %v", err)
+	}
+
+	_, pkR, err := suite.KEM.GenerateKeyPair(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating KEM key pair: %v", err)
+	}
+
+	_, ctx, err := SetupSender(suite, pkR)
+	if err != nil {
+		t.Fatalf("Error in SetupSender: %v", err)
+	}
+
+	if _, err := ctx.Seal(nil, make([]byte, 1<<24)); err == nil {
+		t.Fatalf("Message longer than 2^24 - 1 bytes sealed with SM4-CCM")
+	}
+}
+
+func TestDeriveKeyPair(t *testing.T) {
+	for id, s := range kems {
+		ikm := randomBytes(s.PrivateKeySize())
+
+		_, pkA, err := s.DeriveKeyPair(ikm)
+		if err != nil {
+			t.Fatalf("[%04x] Error deriving key pair: %v", id, err)
+		}
+
+		_, pkB, err := s.DeriveKeyPair(ikm)
+		if err != nil {
+			t.Fatalf("[%04x] Error deriving key pair: %v", id, err)
+		}
+
+		if !bytes.Equal(mustMarshal(t, s, pkA), mustMarshal(t, s, pkB)) {
+			t.Fatalf("[%04x] Non-deterministic key derivation", id)
+		}
+
+		_, pkC, err := s.DeriveKeyPair(randomBytes(s.PrivateKeySize()))
+		if err != nil {
+			t.Fatalf("[%04x] Error deriving key pair: %v", id, err)
+		}
+
+		if bytes.Equal(mustMarshal(t, s, pkA), mustMarshal(t, s, pkC)) {
+			t.Fatalf("[%04x] Distinct IKM derived the same key pair", id)
+		}
+	}
+}
+
+func TestKeyAccessors(t *testing.T) {
+	for id, s := range kems {
+		skA, pkA, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating key pair: %v", id, err)
+		}
+
+		skB, pkB, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating key pair: %v", id, err)
+		}
+
+		if skA.KEMID() != id || pkA.KEMID() != id {
+			t.Fatalf("[%04x] Incorrect key KEMID: %04x %04x", id, skA.KEMID(), pkA.KEMID())
+		}
+
+		if !bytes.Equal(pkA.Bytes(), mustMarshal(t, s, pkA)) {
+			t.Fatalf("[%04x] Public key Bytes and Marshal differ", id)
+		}
+
+		if !bytes.Equal(skA.Bytes(), mustMarshalPrivate(t, s, skA)) {
+			t.Fatalf("[%04x] Private key Bytes and MarshalPrivate differ", id)
+		}
+
+		pkC, err := s.Unmarshal(pkA.Bytes())
+		if err != nil {
+			t.Fatalf("[%04x] Error unmarshaling public key: %v", id, err)
+		}
+
+		skC, err := s.UnmarshalPrivate(skA.Bytes())
+		if err != nil {
+			t.Fatalf("[%04x] Error unmarshaling private key: %v", id, err)
+		}
+
+		if !pkA.Equal(pkA) || !pkA.Equal(pkC) || pkA.Equal(pkB) || pkA.Equal(nil) {
+			t.Fatalf("[%04x] Incorrect public key equality", id)
+		}
+
+		if !skA.Equal(skA) || !skA.Equal(skC) || skA.Equal(skB) || skA.Equal(nil) {
+			t.Fatalf("[%04x] Incorrect private key equality", id)
+		}
+
+		if !skA.Public().Equal(pkA) || !skC.Public().Equal(pkA) {
+			t.Fatalf("[%04x] Public does not return the matching public key", id)
+		}
+	}
+}
+
+// TestKeyMismatch uses the keys of every KEM with every other KEM, and checks
+// that this fails with ErrKeyMismatch instead of panicking.
+func TestKeyMismatch(t *testing.T) {
+	type keyPair struct {
+		sk KEMPrivateKey
+		pk KEMPublicKey
+	}
+
+	keys := map[KEMID]keyPair{}
+	for id, s := range kems {
+		sk, pk, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating key pair: %v", id, err)
+		}
+		keys[id] = keyPair{sk, pk}
+	}
+
+	isMismatch := func(err error) bool {
+		return errors.Is(err, ErrKeyMismatch)
+	}
+
+	for id, s := range kems {
+		own := keys[id]
+		_, enc, err := s.Encap(rand.Reader, own.pk)
+		if err != nil {
+			t.Fatalf("[%04x] Error in Encap: %v", id, err)
+		}
+
+		foreign := map[KEMID]keyPair{0: {nil, nil}}
+		for otherID, pair := range keys {
+			if otherID != id {
+				foreign[otherID] = pair
+			}
+		}
+
+		for otherID, pair := range foreign {
+			if _, err := s.Marshal(pair.pk); !isMismatch(err) {
+				t.Fatalf("[%04x] Marshal with a key of %04x: %v", id, otherID, err)
+			}
+
+			if _, err := s.MarshalPrivate(pair.sk); !isMismatch(err) {
+				t.Fatalf("[%04x] MarshalPrivate with a key of %04x: %v", id, otherID, err)
+			}
+
+			if _, _, err := s.Encap(rand.Reader, pair.pk); !isMismatch(err) {
+				t.Fatalf("[%04x] Encap with a key of %04x: %v", id, otherID, err)
+			}
+
+			if _, err := s.Decap(enc, pair.sk); !isMismatch(err) {
+				t.Fatalf("[%04x] Decap with a key of %04x: %v", id, otherID, err)
+			}
+
+			if ds, ok := s.(DeterministicKEMScheme); ok && ds.EncapSeedSize() > 0 {
+				ikmE := randomBytes(ds.EncapSeedSize())
+				if _, _, err := ds.EncapDeterministic(ikmE, pair.pk); !isMismatch(err) {
+					t.Fatalf("[%04x] EncapDeterministic with a key of %04x: %v", id, otherID, err)
+				}
+			}
+
+			if as, ok := s.(AuthKEMScheme); ok {
+				if _, _, err := as.AuthEncap(rand.Reader, pair.pk, own.sk); !isMismatch(err) {
+					t.Fatalf("[%04x] AuthEncap to a key of %04x: %v", id, otherID, err)
+				}
+
+				if _, _, err := as.AuthEncap(rand.Reader, own.pk, pair.sk); !isMismatch(err) {
+					t.Fatalf("[%04x] AuthEncap from a key of %04x: %v", id, otherID, err)
+				}
+
+				if _, err := as.AuthDecap(enc, pair.sk, own.pk); !isMismatch(err) {
+					t.Fatalf("[%04x] AuthDecap with a key of %04x: %v", id, otherID, err)
+				}
+
+				if _, err := as.AuthDecap(enc, own.sk, pair.pk); !isMismatch(err) {
+					t.Fatalf("[%04x] AuthDecap from a key of %04x: %v", id, otherID, err)
+				}
+			}
+		}
+
+		// The same checks apply through the HPKE API
+		suite, err := AssembleCipherSuite(id, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error assembling ciphersuite: %v", id, err)
+		}
+
+		for otherID, pair := range foreign {
+			if _, _, err := SealBase(suite, rand.Reader, pair.pk, nil, nil, nil); !isMismatch(err) {
+				t.Fatalf("[%04x] SealBase to a key of %04x: %v", id, otherID, err)
+			}
+
+			if _, err := OpenBase(suite, pair.sk, enc, nil, nil, nil); !isMismatch(err) {
+				t.Fatalf("[%04x] OpenBase with a key of %04x: %v", id, otherID, err)
+			}
+		}
+	}
+}
+
+func TestMLKEMPrivateKeySerialization(t *testing.T) {
+	for _, kemID := range []KEMID{KEM_MLKEM768, KEM_MLKEM1024} {
+		s := kems[kemID]
+
+		skA, pkA, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating key pair: %v", kemID, err)
+		}
+
+		seed := mustMarshalPrivate(t, s, skA)
+		if len(seed) != s.PrivateKeySize() {
+			t.Fatalf("[%04x] Incorrect private key size %d != %d", kemID, len(seed), s.PrivateKeySize())
+		}
+
+		skB, err := s.UnmarshalPrivate(seed)
+		if err != nil {
+			t.Fatalf("[%04x] Error parsing private key: %v", kemID, err)
+		}
+
+		if !bytes.Equal(mustMarshal(t, s, pkA), mustMarshal(t, s, skB.PublicKey())) {
+			t.Fatalf("[%04x] Public key changed across private key serialization", kemID)
+		}
+
+		zz, enc, err := s.Encap(rand.Reader, pkA)
+		if err != nil {
+			t.Fatalf("[%04x] Error in KEM encapsulation: %v", kemID, err)
+		}
+
+		zzB, err := s.Decap(enc, skB)
+		if err != nil {
+			t.Fatalf("[%04x] Error in KEM decapsulation: %v", kemID, err)
+		}
+
+		if !bytes.Equal(zz, zzB) {
+			t.Fatalf("[%04x] Asymmetric KEM results [%x] != [%x]", kemID, zz, zzB)
+		}
+
+		if _, err := s.UnmarshalPrivate(seed[1:]); err == nil {
+			t.Fatalf("[%04x] Truncated private key accepted", kemID)
+		}
+	}
+}
+
+// TestFrodoKEM checks that the circl wrapper keeps circl's sizes and encodings,
+// and that keys of the wrong size are rejected with an error.
+func TestFrodoKEM(t *testing.T) {
+	s := kems[KEM_FRODO640SHAKE]
+	scheme := frodo640shake.Scheme()
+
+	if s.PublicKeySize() != frodo640shake.PublicKeySize || s.PrivateKeySize() != frodo640shake.PrivateKeySize {
+		t.Fatalf("[%04x] Incorrect key sizes %d, %d", KEM_FRODO640SHAKE, s.PublicKeySize(), s.PrivateKeySize())
+	}
+
+	skR, pkR, err := s.DeriveKeyPair([]byte("ikm"))
+	if err != nil {
+		t.Fatalf("[%04x] Error deriving key pair: %v", KEM_FRODO640SHAKE, err)
+	}
+
+	// The encodings are those of circl.
+	circlSk, err := scheme.UnmarshalBinaryPrivateKey(mustMarshalPrivate(t, s, skR))
+	if err != nil {
+		t.Fatalf("[%04x] Private key rejected by circl: %v", KEM_FRODO640SHAKE, err)
+	}
+
+	enc, zz, err := scheme.Encapsulate(circlSk.Public())
+	if err != nil {
+		t.Fatalf("[%04x] Error in circl encapsulation: %v", KEM_FRODO640SHAKE, err)
+	}
+
+	zzR, err := s.Decap(enc, skR)
+	if err != nil {
+		t.Fatalf("[%04x] Error in KEM decapsulation: %v", KEM_FRODO640SHAKE, err)
+	}
+
+	if !bytes.Equal(zz, zzR) {
+		t.Fatalf("[%04x] Asymmetric KEM results [%x] != [%x]", KEM_FRODO640SHAKE, zz, zzR)
+	}
+
+	skB, err := s.UnmarshalPrivate(mustMarshalPrivate(t, s, skR))
+	if err != nil {
+		t.Fatalf("[%04x] Error parsing private key: %v", KEM_FRODO640SHAKE, err)
+	}
+
+	if !skB.Equal(skR) || !skB.PublicKey().Equal(pkR) {
+		t.Fatalf("[%04x] Key pair changed across private key serialization", KEM_FRODO640SHAKE)
+	}
+
+	pkm := mustMarshal(t, s, pkR)
+	skm := mustMarshalPrivate(t, s, skR)
+	for _, pk := range [][]byte{pkm[1:], append(pkm, 0)} {
+		if _, err := s.Unmarshal(pk); !errors.Is(err, ErrInvalidKEMPublicKey) {
+			t.Fatalf("[%04x] Public key of %d bytes accepted: %v", KEM_FRODO640SHAKE, len(pk), err)
+		}
+	}
+	for _, sk := range [][]byte{skm[1:], append(skm, 0)} {
+		if _, err := s.UnmarshalPrivate(sk); !errors.Is(err, ErrInvalidKEMPrivateKey) {
+			t.Fatalf("[%04x] Private key of %d bytes accepted: %v", KEM_FRODO640SHAKE, len(sk), err)
+		}
+	}
+
+	if _, err := s.Decap(enc[1:], skR); !errors.Is(err, ErrInvalidKEMPublicKey) {
+		t.Fatalf("[%04x] Truncated ciphertext accepted", KEM_FRODO640SHAKE)
+	}
+}
+
+// readXWingTestVectors parses the test-vectors.txt format of
+// draft-connolly-cfrg-xwing-kem, in which each hex value follows its name on
+// the same line or on indented continuation lines.
+func readXWingTestVectors(t *testing.T, file string) []map[string][]byte {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("Failed reading test vectors: %v", err)
+	}
+
+	vectors := []map[string][]byte{}
+	for _, block := range strings.Split(strings.TrimSpace(string(data)), "\n\n") {
+		vector := map[string][]byte{}
+		name := ""
+		value := ""
+		for _, line := range strings.Split(block, "\n") {
+			if !strings.HasPrefix(line, " ") {
+				fields := strings.Fields(line)
+				name = fields[0]
+				value = ""
+				if len(fields) > 1 {
+					value = fields[1]
+				}
+			} else {
+				value += strings.TrimSpace(line)
+			}
+
+			vector[name], err = hex.DecodeString(value)
+			if err != nil {
+				t.Fatalf("Invalid hex value for %s: %v", name, err)
+			}
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors
+}
+
+// TestRSAKEM checks the RSA KEMs against the big.Int arithmetic of the raw RSA
+// function, with generated keys and with keys imported from crypto/rsa.
+func TestRSAKEM(t *testing.T) {
+	for _, kemID := range []KEMID{KEM_RSA2048, KEM_RSA3072, KEM_RSA4096} {
+		kem, ok := newKEMScheme(kemID, VersionRFC9180)
+		if !ok {
+			t.Fatalf("[%04x] Unknown KEM", kemID)
+		}
+		s := kem.(*rsaKEMScheme)
+
+		skR, pkR, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating key pair: %v", kemID, err)
+		}
+
+		if len(mustMarshal(t, s, pkR)) != s.PublicKeySize() || len(mustMarshalPrivate(t, s, skR)) > s.PrivateKeySize() {
+			t.Fatalf("[%04x] Incorrect key sizes %d, %d", kemID, len(mustMarshal(t, s, pkR)), len(mustMarshalPrivate(t, s, skR)))
+		}
+
+		// Decap inverts the raw RSA function
+		priv := skR.(*rsaPrivateKey).priv
+		z, err := rand.Int(rand.Reader, priv.N)
+		if err != nil {
+			t.Fatalf("[%04x] Error sampling z: %v", kemID, err)
+		}
+
+		enc := new(big.Int).Exp(z, big.NewInt(int64(priv.E)), priv.N).FillBytes(make([]byte, s.bits/8))
+
+		sharedSecret, err := s.Decap(enc, skR)
+		if err != nil {
+			t.Fatalf("[%04x] Error in KEM decapsulation: %v", kemID, err)
+		}
+
+		// KDF3 with SHA-256, for which a single block suffices
+		expected := sha256.Sum256(append([]byte{0, 0, 0, 1}, z.FillBytes(make([]byte, s.bits/8))...))
+		if !bytes.Equal(sharedSecret, expected[:]) {
+			t.Fatalf("[%04x] Incorrect shared secret [%x] != [%x]", kemID, sharedSecret, expected[:])
+		}
+
+		// Ciphertexts that are not less than n, or have the wrong size
+		for _, invalid := range [][]byte{priv.N.Bytes(), bytes.Repeat([]byte{0xff}, s.bits/8), enc[1:]} {
+			if _, err := s.Decap(invalid, skR); !errors.Is(err, ErrInvalidKEMPublicKey) {
+				t.Fatalf("[%04x] Invalid ciphertext accepted: %v", kemID, err)
+			}
+		}
+	}
+
+	// Keys from existing PKI, in PKCS #1 and PKCS #8 encodings
+	s := &rsaKEMScheme{kemID: KEM_RSA2048, bits: 2048, KDF: hkdfScheme{hash: crypto.SHA256}}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Error marshaling RSA key: %v", err)
+	}
+
+	skR, err := s.UnmarshalPrivate(pkcs8)
+	if err != nil {
+		t.Fatalf("Error unmarshaling PKCS #8 private key: %v", err)
+	}
+
+	pkR, err := s.Unmarshal(x509.MarshalPKCS1PublicKey(&priv.PublicKey))
+	if err != nil {
+		t.Fatalf("Error unmarshaling PKCS #1 public key: %v", err)
+	}
+
+	if !pkR.Equal(skR.PublicKey()) {
+		t.Fatalf("Unmarshaled public key does not match the private key")
+	}
+
+	suite, err := AssembleCipherSuite(KEM_RSA2048, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	for mode, setup := range setupModes {
+		if !suite.SupportsMode(mode) {
+			_, _, err := setup.I(suite, pkR, info, skR, fixedPSK, fixedPSKID)
+			assert(t, suite, "Unsupported mode not reported", errors.Is(err, ErrAuthNotSupported))
+			continue
+		}
+
+		enc, ctxI, err := setup.I(suite, pkR, info, nil, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, "Error in SetupI", err)
+
+		ctxR, err := setup.R(suite, skR, enc, info, nil, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, "Error in SetupR", err)
+
+		encrypted, err := ctxI.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+
+		decrypted, err := ctxR.Open(aad, encrypted)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	}
+
+	// Keys of the wrong size or type
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+
+	if _, err := s.UnmarshalPrivate(x509.MarshalPKCS1PrivateKey(other)); !errors.Is(err, ErrInvalidKEMPrivateKey) {
+		t.Fatalf("Private key of the wrong size accepted: %v", err)
+	}
+
+	if _, err := s.Unmarshal(x509.MarshalPKCS1PublicKey(&other.PublicKey)); !errors.Is(err, ErrInvalidKEMPublicKey) {
+		t.Fatalf("Public key of the wrong size accepted: %v", err)
+	}
+
+	_, pkX, err := kems[DHKEM_P256].GenerateKeyPair(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key pair: %v", err)
+	}
+
+	spki, err := x509.MarshalPKIXPublicKey(pkX.(*ecdhPublicKey).pub)
+	if err != nil {
+		t.Fatalf("Error marshaling EC key: %v", err)
+	}
+
+	if _, err := s.Unmarshal(spki); !errors.Is(err, ErrInvalidKEMPublicKey) {
+		t.Fatalf("EC public key accepted: %v", err)
+	}
+}
+
+func TestXWingVectors(t *testing.T) {
+	s := kems[KEM_XWING]
+
+	vectors := readXWingTestVectors(t, "testdata/xwing-test-vectors.txt")
+	if len(vectors) == 0 {
+		t.Fatalf("No X-Wing test vectors found")
+	}
+
+	for i, tv := range vectors {
+		sk, err := s.UnmarshalPrivate(tv["seed"])
+		if err != nil {
+			t.Fatalf("[%d] Error parsing private key: %v", i, err)
+		}
+
+		if !bytes.Equal(mustMarshalPrivate(t, s, sk), tv["sk"]) {
+			t.Fatalf("[%d] Incorrect private key [%x] != [%x]", i, mustMarshalPrivate(t, s, sk), tv["sk"])
+		}
+
+		if !bytes.Equal(mustMarshal(t, s, sk.PublicKey()), tv["pk"]) {
+			t.Fatalf("[%d] Incorrect public key [%x] != [%x]", i, mustMarshal(t, s, sk.PublicKey()), tv["pk"])
+		}
+
+		// crypto/mlkem cannot be derandomized with eseed, so only
+		// decapsulation is checked against the expected shared secret.
+		ss, err := s.Decap(tv["ct"], sk)
+		if err != nil {
+			t.Fatalf("[%d] Error in KEM decapsulation: %v", i, err)
+		}
+
+		if !bytes.Equal(ss, tv["ss"]) {
+			t.Fatalf("[%d] Incorrect shared secret [%x] != [%x]", i, ss, tv["ss"])
+		}
+	}
+}
+
+func TestRegisterHybridKEM(t *testing.T) {
+	kemID := KEMID(0xFF10)
+	err := RegisterHybridKEM(kemID, "MLKEM1024-P521", DHKEM_P521, KEM_MLKEM1024, KDF_HKDF_SHA3_256)
+	if err != nil {
+		t.Fatalf("Error registering hybrid KEM: %v", err)
+	}
+
+	suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error assembling ciphersuite: %v", err)
+	}
+
+	skR, pkR, err := suite.KEM.DeriveKeyPair(randomBytes(32))
+	if err != nil {
+		t.Fatalf("Error deriving key pair: %v", err)
+	}
+
+	pt := randomBytes(1024)
+	enc, ct, err := SealBase(suite, rand.Reader, pkR, nil, nil, pt)
+	if err != nil {
+		t.Fatalf("Error in SealBase: %v", err)
+	}
+
+	if len(enc) != mlkem.CiphertextSize1024+suite.KEM.PublicKeySize()-mlkem.EncapsulationKeySize1024 {
+		t.Fatalf("Incorrect encapsulated key size: %d", len(enc))
+	}
+
+	opened, err := OpenBase(suite, skR, enc, nil, nil, ct)
+	if err != nil {
+		t.Fatalf("Error in OpenBase: %v", err)
+	}
+
+	if !bytes.Equal(opened, pt) {
+		t.Fatalf("Incorrect decryption [%x] != [%x]", opened, pt)
+	}
+
+	invalid := []struct {
+		kemID   KEMID
+		dhkemID KEMID
+		pqKEMID KEMID
+		kdfID   KDFID
+	}{
+		{kemID, DHKEM_P256, KEM_MLKEM768, KDF_HKDF_SHA3_256},
+		{KEM_MLKEM768_P256, DHKEM_P256, KEM_MLKEM768, KDF_HKDF_SHA3_256},
+		{0xFF11, KEM_MLKEM768, KEM_MLKEM768, KDF_HKDF_SHA3_256},
+		{0xFF11, DHKEM_P256, 0xFF12, KDF_HKDF_SHA3_256},
+		{0xFF11, DHKEM_P256, KEM_MLKEM768, 0xFF13},
+	}
+
+	for i, params := range invalid {
+		err := RegisterHybridKEM(params.kemID, "invalid", params.dhkemID, params.pqKEMID, params.kdfID)
+		if err == nil {
+			t.Fatalf("[%d] Invalid hybrid KEM registered", i)
+		}
+	}
+
+	if _, err := AssembleCipherSuite(0xFF11, KDF_HKDF_SHA256, AEAD_AESGCM128); err == nil {
+		t.Fatalf("Ciphersuite assembled with an unregistered KEM")
+	}
+}
+
+// testGroup, testKDF and testAEAD re-register built-in algorithms under
+// private-use identifiers, standing in for third-party implementations.
+type testGroup struct {
+	x25519Scheme
+}
+
+func (g testGroup) ID() KEMID {
+	return 0xFF20
+}
+
+type testKDF struct {
+	hkdfScheme
+}
+
+func (k testKDF) ID() KDFID {
+	return 0xFF21
+}
+
+type testAEAD struct {
+	chachaPolyScheme
+}
+
+func (a testAEAD) ID() AEADID {
+	return 0xFF22
+}
+
+func TestRegistry(t *testing.T) {
+	kemID, kdfID, aeadID := KEMID(0xFF20), KDFID(0xFF21), AEADID(0xFF22)
+
+	err := RegisterKEM(kemID, func() KEMScheme { return NewDHKEM(testGroup{}, hkdfScheme{hash: crypto.SHA256}) })
+	if err != nil {
+		t.Fatalf("Error registering KEM: %v", err)
+	}
+
+	err = RegisterKDF(kdfID, func() KDFScheme { return testKDF{hkdfScheme{hash: crypto.SHA256}} })
+	if err != nil {
+		t.Fatalf("Error registering KDF: %v", err)
+	}
+
+	err = RegisterAEAD(aeadID, func() AEADScheme { return testAEAD{} })
+	if err != nil {
+		t.Fatalf("Error registering AEAD: %v", err)
+	}
+
+	// A DHKEM over a registered group supports every mode
+	for mode, setup := range setupModes {
+		label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%02x", kemID, kdfID, aeadID, mode)
+		rtt := roundTripTest{VersionRFC9180, kemID, kdfID, aeadID, setup}
+		t.Run(label, rtt.Test)
+	}
+
+	// Registered algorithms mix with pre-defined ones
+	if _, err := AssembleCipherSuite(DHKEM_X25519, kdfID, AEAD_AESGCM128); err != nil {
+		t.Fatalf("Error assembling ciphersuite with registered KDF: %v", err)
+	}
+
+	// A registered DH group can be used in a hybrid KEM
+	err = RegisterHybridKEM(0xFF23, "MLKEM768-TEST", kemID, KEM_MLKEM768, KDF_HKDF_SHA3_256)
+	if err != nil {
+		t.Fatalf("Error registering hybrid KEM over a registered group: %v", err)
+	}
+
+	// Registered KEMs only speak RFC 9180
+	if _, err := AssembleVersionedCipherSuite(VersionDraft, kemID, KDF_HKDF_SHA256, AEAD_AESGCM128); err == nil {
+		t.Fatalf("Registered KEM used with the draft protocol version")
+	}
+
+	kem := func() KEMScheme { return NewDHKEM(testGroup{}, hkdfScheme{hash: crypto.SHA256}) }
+	kdf := func() KDFScheme { return testKDF{hkdfScheme{hash: crypto.SHA256}} }
+	aead := func() AEADScheme { return testAEAD{} }
+	conflicts := []struct {
+		name string
+		err  error
+	}{
+		{"registered KEM", RegisterKEM(kemID, kem)},
+		{"pre-defined KEM", RegisterKEM(DHKEM_X25519, func() KEMScheme { return kems[DHKEM_X25519] })},
+		{"withdrawn KEM", RegisterKEM(KEM_SIKE503, kem)},
+		{"KEM with another id", RegisterKEM(0xFF24, kem)},
+		{"registered KDF", RegisterKDF(kdfID, kdf)},
+		{"pre-defined KDF", RegisterKDF(KDF_HKDF_SHA256, func() KDFScheme { return kdfs[KDF_HKDF_SHA256] })},
+		{"KDF with another id", RegisterKDF(0xFF24, kdf)},
+		{"registered AEAD", RegisterAEAD(aeadID, aead)},
+		{"pre-defined AEAD", RegisterAEAD(AEAD_AESGCM128, func() AEADScheme { return aeads[AEAD_AESGCM128] })},
+		{"AEAD with another id", RegisterAEAD(0xFF24, aead)},
+	}
+
+	for _, c := range conflicts {
+		if c.err == nil {
+			t.Fatalf("Registration succeeded for %s", c.name)
+		}
+	}
+}
+
+///////
+// Benchmarks
+
+// genericCurve returns a copy of the parameters of curve. Unlike the
+// parameters of the standard curves themselves, a copy always uses the
+// generic big.Int arithmetic of elliptic.CurveParams.
+func genericCurve(curve elliptic.Curve) *elliptic.CurveParams {
+	params := *curve.Params()
+	return &params
+}
+
+// BenchmarkECDH compares the crypto/ecdh implementation of the NIST curves
+// with the generic big.Int arithmetic that it replaces.
+func BenchmarkECDH(b *testing.B) {
+	curves := []struct {
+		name    string
+		curve   ecdh.Curve
+		generic *elliptic.CurveParams
+	}{
+		{"P-256", ecdh.P256(), genericCurve(elliptic.P256())},
+		{"P-384", ecdh.P384(), genericCurve(elliptic.P384())},
+		{"P-521", ecdh.P521(), genericCurve(elliptic.P521())},
+	}
+
+	for _, c := range curves {
+		s := ecdhScheme{curve: c.curve}
+		skA, _, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			b.Fatalf("Error generating DH key pair: %v", err)
+		}
+		_, pkB, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			b.Fatalf("Error generating DH key pair: %v", err)
+		}
+
+		b.Run(c.name+"/ecdh", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := s.DH(skA, pkB); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		d := mustMarshalPrivate(b, s, skA)
+		x, y := elliptic.Unmarshal(c.generic, mustMarshal(b, s, pkB))
+		b.Run(c.name+"/generic", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.generic.ScalarMult(x, y, d)
+			}
+		})
+	}
+}
+
+func BenchmarkDHKEM(b *testing.B) {
+	for _, kemID := range []KEMID{DHKEM_P256, DHKEM_P384, DHKEM_P521} {
+		s := kems[kemID]
+		skR, pkR, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			b.Fatalf("Error generating KEM key pair: %v", err)
+		}
+		_, enc, err := s.Encap(rand.Reader, pkR)
+		if err != nil {
+			b.Fatalf("Error in KEM encapsulation: %v", err)
+		}
+
+		b.Run(fmt.Sprintf("%04x/Encap", kemID), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Encap(rand.Reader, pkR)
+			}
+		})
+
+		b.Run(fmt.Sprintf("%04x/Decap", kemID), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s.Decap(enc, skR)
+			}
+		})
+	}
+}
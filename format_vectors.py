@@ -62,29 +62,57 @@ modeMap = {
 }
 
 kem_idP256 = 0x0010
+kem_idP384 = 0x0011
 kem_idP521 = 0x0012
 kem_idX25519 = 0x0020
+kem_idSM2 = 0xFF70
+kem_idSecp256k1 = 0xFF71
+kem_idSecp256k1Compressed = 0xFF72
+kem_idBrainpoolP256r1 = 0xFF73
+kem_idBrainpoolP384r1 = 0xFF74
+kem_idBrainpoolP512r1 = 0xFF75
+kem_idRSA2048 = 0xFF80
+kem_idRSA3072 = 0xFF81
+kem_idFrodo640SHAKE = 0xFF90
 kemMap = {
     kem_idX25519: "DHKEM(X25519, HKDF-SHA256)", 
     kem_idP256: "DHKEM(P-256, HKDF-SHA256)", 
-    kem_idP521: "DHKEM(P-521, HKDF-SHA512)"
+    kem_idP384: "DHKEM(P-384, HKDF-SHA384)", 
+    kem_idP521: "DHKEM(P-521, HKDF-SHA512)", 
+    kem_idSM2: "DHKEM(SM2, HKDF-SM3)", 
+    kem_idSecp256k1: "DHKEM(secp256k1, HKDF-SHA256)", 
+    kem_idSecp256k1Compressed: "DHKEM(secp256k1 compressed, HKDF-SHA256)", 
+    kem_idBrainpoolP256r1: "DHKEM(brainpoolP256r1, HKDF-SHA256)", 
+    kem_idBrainpoolP384r1: "DHKEM(brainpoolP384r1, HKDF-SHA384)", 
+    kem_idBrainpoolP512r1: "DHKEM(brainpoolP512r1, HKDF-SHA512)", 
+    kem_idRSA2048: "RSA-KEM(2048, HKDF-SHA256)", 
+    kem_idRSA3072: "RSA-KEM(3072, HKDF-SHA256)", 
+    kem_idFrodo640SHAKE: "FrodoKEM-640-SHAKE"
 }
 
 kdf_idSHA256 = 0x0001
+kdf_idSHA384 = 0x0002
 kdf_idSHA512 = 0x0003
+kdf_idSM3 = 0xFF70
 kdfMap = {
     kdf_idSHA256: "HKDF-SHA256", 
-    kdf_idSHA512: "HKDF-SHA512"
+    kdf_idSHA384: "HKDF-SHA384", 
+    kdf_idSHA512: "HKDF-SHA512", 
+    kdf_idSM3: "HKDF-SM3"
 }
 
 aead_idAES128GCM = 0x0001
 aead_idAES256GCM = 0x0002
 aead_idChaCha20Poly1305 = 0x0003
+aead_idSM4GCM = 0xFF70
+aead_idSM4CCM = 0xFF71
 aead_idExportOnly = 0xFFFF
 aeadMap = {
     aead_idAES128GCM: "AES-128-GCM", 
     aead_idAES256GCM: "AES-256-GCM", 
     aead_idChaCha20Poly1305: "ChaCha20Poly1305", 
+    aead_idSM4GCM: "SM4-GCM", 
+    aead_idSM4CCM: "SM4-CCM", 
     aead_idExportOnly: "Export-Only AEAD"
 }
 
@@ -109,8 +137,20 @@ testSuites = [
     CipherSuite(kem_idP256, kdf_idSHA256, aead_idAES128GCM),
     CipherSuite(kem_idP256, kdf_idSHA512, aead_idAES128GCM),
     CipherSuite(kem_idP256, kdf_idSHA256, aead_idChaCha20Poly1305),
+    CipherSuite(kem_idP384, kdf_idSHA384, aead_idAES256GCM),
     CipherSuite(kem_idP521, kdf_idSHA512, aead_idAES256GCM),
     CipherSuite(kem_idX25519, kdf_idSHA256, aead_idExportOnly),
+    CipherSuite(kem_idSM2, kdf_idSM3, aead_idSM4GCM),
+    CipherSuite(kem_idSM2, kdf_idSM3, aead_idSM4CCM),
+    CipherSuite(kem_idSecp256k1, kdf_idSHA256, aead_idAES128GCM),
+    CipherSuite(kem_idSecp256k1Compressed, kdf_idSHA256, aead_idAES128GCM),
+    CipherSuite(kem_idBrainpoolP256r1, kdf_idSHA256, aead_idAES128GCM),
+    CipherSuite(kem_idBrainpoolP384r1, kdf_idSHA384, aead_idAES256GCM),
+    CipherSuite(kem_idBrainpoolP512r1, kdf_idSHA512, aead_idAES256GCM),
+    CipherSuite(kem_idRSA2048, kdf_idSHA256, aead_idAES128GCM),
+    CipherSuite(kem_idRSA3072, kdf_idSHA256, aead_idAES128GCM),
+    CipherSuite(kem_idFrodo640SHAKE, kdf_idSHA256, aead_idAES128GCM),
+    CipherSuite(kem_idFrodo640SHAKE, kdf_idSHA256, aead_idExportOnly),
 ]
 
 def wrap_line(value):
@@ -3,10 +3,14 @@ module github.com/ying-0621/hpke
 go 1.24.1
 
 require (
+	filippo.io/bigmod v0.1.0
 	git.schwanenlied.me/yawning/x448.git v0.0.0-20170617130356-01b048fb03d6
 	github.com/cisco/go-tls-syntax v0.0.0-20200617162716-46b0cfb76b9b
 	github.com/cloudflare/circl v1.6.0
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
 	github.com/stretchr/testify v1.10.0
+	github.com/tjfoc/gmsm v1.4.1
+	gitlab.com/yawning/secp256k1-voi v0.0.0-20230925100816-f2616030848b
 	golang.org/x/crypto v0.36.0
 )
 
@@ -30,6 +34,7 @@ require (
 	github.com/spf13/pflag v1.0.6 // indirect
 	github.com/spf13/viper v1.19.0 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
+	gitlab.com/yawning/tuplehash v0.0.0-20230713102510-df83abbf9a02 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/exp v0.0.0-20250305212735-054e65f0b394 // indirect
 	golang.org/x/sys v0.31.0 // indirect
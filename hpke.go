@@ -2,39 +2,115 @@ package hpke
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/cipher"
+	cryptorand "crypto/rand"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 
 	"github.com/cisco/go-tls-syntax"
 )
 
+// ErrAuthNotSupported is returned by the Auth and AuthPSK mode functions when
+// the ciphersuite's KEM does not implement AuthKEMScheme.
+var ErrAuthNotSupported = errors.New("KEM does not support Auth mode")
+
+// ErrBadSignature is returned by the signed setup functions when the sender's
+// signature does not verify.
+var ErrBadSignature = errors.New("Invalid sender signature")
+
+// ErrExportLengthTooLarge is returned when more than 255 times the KDF's
+// output size is requested from an exporter.
+var ErrExportLengthTooLarge = errors.New("Export length too large")
+
+// ErrExportOnly is returned by Seal and Open on contexts whose ciphersuite
+// uses the export-only AEAD identifier.
+var ErrExportOnly = errors.New("Seal and Open are not available with an export-only AEAD")
+
+// ErrInvalidKEMPrivateKey is returned when a private key cannot be
+// unmarshaled.
+var ErrInvalidKEMPrivateKey = errors.New("Invalid KEM private key")
+
+// ErrInvalidKEMPublicKey is returned when a public key, including an
+// encapsulated key, cannot be unmarshaled.
+var ErrInvalidKEMPublicKey = errors.New("Invalid KEM public key")
+
+// ErrInvalidPSKConfig is returned when the PSK and PSK ID are inconsistent
+// with each other or with the mode.
+var ErrInvalidPSKConfig = errors.New("Invalid PSK configuration")
+
+// ErrPSKWithoutID, ErrPSKIDWithoutPSK, ErrMissingPSK, ErrUnexpectedPSK and
+// ErrPSKTooShort tell apart the invalid PSK inputs of RFC 9180, Section 5.1.
+// They are always wrapped together with ErrInvalidPSKConfig.
+var (
+	ErrPSKWithoutID    = errors.New("PSK given without a PSK ID")
+	ErrPSKIDWithoutPSK = errors.New("PSK ID given without a PSK")
+	ErrMissingPSK      = errors.New("Missing required PSK input")
+	ErrUnexpectedPSK   = errors.New("PSK input provided when not needed")
+	ErrPSKTooShort     = errors.New("PSK shorter than 32 bytes")
+)
+
+// ErrKEMWithdrawn is returned when assembling a ciphersuite whose KEM has been
+// withdrawn because it is no longer secure.
+var ErrKEMWithdrawn = errors.New("KEM withdrawn")
+
+// ErrKeyMismatch is returned when a key is used with a KEM other than the
+// one it belongs to, e.g., a P-256 key with an X25519 ciphersuite.
+var ErrKeyMismatch = errors.New("Key does not belong to the KEM")
+
+// ErrLowOrderPoint is returned when an X25519 or X448 public key is a
+// low-order point, so that the DH output would be all zero.
+var ErrLowOrderPoint = errors.New("Low-order public key")
+
+// ErrMessageLimitReached is returned by Seal and Open once the sequence number
+// of a context is exhausted.
+var ErrMessageLimitReached = errors.New("Message limit reached")
+
+// ErrOpen is returned by Open when a ciphertext fails to authenticate.
+var ErrOpen = errors.New("Error opening ciphertext")
+
 const (
-	debug    = true
-	rfcLabel = "RFCXXXX"
+	debug      = true
+	rfcLabel   = "HPKE-v1"
+	draftLabel = "RFCXXXX "
 )
 
+// KEMPrivateKey is a private key of a KEM. Bytes returns the same encoding
+// as the KEM's MarshalPrivate, and Public and PublicKey both return the
+// matching public key.
 type KEMPrivateKey interface {
+	KEMID() KEMID
+	Bytes() []byte
+	Equal(other KEMPrivateKey) bool
+	Public() KEMPublicKey
 	PublicKey() KEMPublicKey
 }
 
-type KEMPublicKey interface{}
+// KEMPublicKey is a public key of a KEM. Bytes returns the same encoding as
+// the KEM's Marshal.
+type KEMPublicKey interface {
+	KEMID() KEMID
+	Bytes() []byte
+	Equal(other KEMPublicKey) bool
+}
 
 type KEMScheme interface {
 	ID() KEMID
 	GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error)
-	Marshal(pk KEMPublicKey) []byte
+	DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error)
+	Marshal(pk KEMPublicKey) ([]byte, error)
 	Unmarshal(enc []byte) (KEMPublicKey, error)
 	Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error)
 	Decap(enc []byte, skR KEMPrivateKey) ([]byte, error)
 	PublicKeySize() int
+	PrivateKeySize() int
 
-	MarshalPrivate(sk KEMPrivateKey) []byte
+	MarshalPrivate(sk KEMPrivateKey) ([]byte, error)
 	UnmarshalPrivate(enc []byte) (KEMPrivateKey, error)
-
-	setEphemeralKeyPair(sk KEMPrivateKey)
 }
 
 type AuthKEMScheme interface {
@@ -43,13 +119,33 @@ type AuthKEMScheme interface {
 	AuthDecap(enc []byte, skR KEMPrivateKey, pkS KEMPublicKey) ([]byte, error)
 }
 
+// DeterministicKEMScheme is implemented by KEMs whose encapsulation can be
+// derandomized, for instance to reproduce test vectors.  EncapDeterministic
+// takes all of its randomness from ikmE instead of a random source: DHKEMs
+// derive the ephemeral key pair from it with DeriveKeyPair, and KEMs without
+// an ephemeral key pair use it as their encapsulation randomness, which must
+// then be exactly EncapSeedSize bytes long.  Neither call modifies the scheme,
+// so a scheme can be shared between goroutines.
+type DeterministicKEMScheme interface {
+	KEMScheme
+	EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error)
+	EncapSeedSize() int
+}
+
+// DeterministicAuthKEMScheme is the Auth mode counterpart of
+// DeterministicKEMScheme.
+type DeterministicAuthKEMScheme interface {
+	AuthKEMScheme
+	AuthEncapDeterministic(ikmE []byte, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error)
+}
+
 type KDFScheme interface {
 	ID() KDFID
 	Hash(message []byte) []byte
 	Extract(salt, ikm []byte) []byte
 	Expand(prk, info []byte, L int) []byte
-	LabeledExtract(salt []byte, label string, ikm []byte) []byte
-	LabeledExpand(prk []byte, label string, info []byte, L int) []byte
+	LabeledExtract(salt []byte, suiteID []byte, label string, ikm []byte) []byte
+	LabeledExpand(prk []byte, suiteID []byte, label string, info []byte, L int) ([]byte, error)
 	OutputSize() int
 }
 
@@ -61,20 +157,111 @@ type AEADScheme interface {
 }
 
 type CipherSuite struct {
-	KEM  KEMScheme
-	KDF  KDFScheme
-	AEAD AEADScheme
+	KEM     KEMScheme
+	KDF     KDFScheme
+	AEAD    AEADScheme
+	Version Version
+}
+
+// suiteID returns the identifier used to domain-separate the key schedule's
+// invocations of the KDF, i.e., "HPKE" || I2OSP(kem_id, 2) ||
+// I2OSP(kdf_id, 2) || I2OSP(aead_id, 2).
+func (suite CipherSuite) suiteID() []byte {
+	suiteID := make([]byte, 10)
+	copy(suiteID, "HPKE")
+	binary.BigEndian.PutUint16(suiteID[4:], uint16(suite.KEM.ID()))
+	binary.BigEndian.PutUint16(suiteID[6:], uint16(suite.KDF.ID()))
+	binary.BigEndian.PutUint16(suiteID[8:], uint16(suite.AEAD.ID()))
+	return suiteID
+}
+
+// SupportsMode reports whether the suite can be used in the given mode. The
+// Auth and AuthPSK modes require a KEM that implements AuthKEMScheme; with
+// other KEMs, such as ML-KEM, senders can authenticate with a signature
+// instead, see SetupSignedS.
+func (suite CipherSuite) SupportsMode(mode HPKEMode) bool {
+	switch mode {
+	case ModeBase, ModePSK:
+		return true
+	case ModeAuth, ModeAuthPSK:
+		_, ok := suite.KEM.(AuthKEMScheme)
+		return ok
+	}
+	return false
 }
 
+func (suite CipherSuite) labeledExtract(salt []byte, label string, ikm []byte) []byte {
+	return suite.Version.labeledExtract(suite.KDF, salt, suite.suiteID(), label, ikm)
+}
+
+func (suite CipherSuite) labeledExpand(prk []byte, label string, info []byte, L int) ([]byte, error) {
+	return suite.Version.labeledExpand(suite.KDF, prk, suite.suiteID(), label, info, L)
+}
+
+// HPKEMode is one of the four modes of RFC 9180, Section 5. SetupSender and
+// SetupRecipient pick the mode from the options they are given.
 type HPKEMode uint8
 
 const (
-	modeBase    HPKEMode = 0x00
-	modePSK     HPKEMode = 0x01
-	modeAuth    HPKEMode = 0x02
-	modeAuthPSK HPKEMode = 0x03
+	ModeBase    HPKEMode = 0x00
+	ModePSK     HPKEMode = 0x01
+	ModeAuth    HPKEMode = 0x02
+	ModeAuthPSK HPKEMode = 0x03
+)
+
+// Version selects the protocol version spoken by a CipherSuite.  The zero
+// value is the final RFC 9180 protocol.  VersionDraft is the pre-standard key
+// schedule ("RFCXXXX " labels, no suite_id) that this package implemented
+// before RFC 9180 was published; it is only kept to talk to peers that have
+// not migrated yet.
+type Version uint8
+
+const (
+	VersionRFC9180 Version = 0x00
+	VersionDraft   Version = 0x01
 )
 
+// draftLabels maps the RFC 9180 labels onto the ones used by VersionDraft.
+// Labels that did not change are not listed.
+var draftLabels = map[string]string{
+	"eae_prk":       "dh",
+	"shared_secret": "prk",
+	"psk_id_hash":   "pskID_hash",
+	"base_nonce":    "nonce",
+}
+
+func (v Version) draftLabelFor(label string) string {
+	if draft, ok := draftLabels[label]; ok {
+		label = draft
+	}
+	return draftLabel + label
+}
+
+func (v Version) labeledExtract(kdf KDFScheme, salt, suiteID []byte, label string, ikm []byte) []byte {
+	if v == VersionDraft {
+		labeledIKM := append([]byte(v.draftLabelFor(label)), ikm...)
+		return kdf.Extract(salt, labeledIKM)
+	}
+
+	return kdf.LabeledExtract(salt, suiteID, label, ikm)
+}
+
+func (v Version) labeledExpand(kdf KDFScheme, prk, suiteID []byte, label string, info []byte, L int) ([]byte, error) {
+	if v == VersionDraft {
+		if err := checkExportLength(kdf, L); err != nil {
+			return nil, err
+		}
+
+		labeledInfo := make([]byte, 2)
+		binary.BigEndian.PutUint16(labeledInfo, uint16(L))
+		labeledInfo = append(labeledInfo, []byte(v.draftLabelFor(label))...)
+		labeledInfo = append(labeledInfo, info...)
+		return kdf.Expand(prk, labeledInfo, L), nil
+	}
+
+	return kdf.LabeledExpand(prk, suiteID, label, info, L)
+}
+
 func logString(val string) {
 	if debug {
 		log.Printf("%s", val)
@@ -90,48 +277,62 @@ func logVal(name string, value []byte) {
 ///////
 // Core
 
-func defaultPKIm(suite CipherSuite) []byte {
-	return bytes.Repeat([]byte{0x00}, suite.KEM.PublicKeySize())
-}
-
 func defaultPSK(suite CipherSuite) []byte {
-	return bytes.Repeat([]byte{0x00}, int(suite.KDF.OutputSize()))
+	if suite.Version == VersionDraft {
+		return bytes.Repeat([]byte{0x00}, suite.KDF.OutputSize())
+	}
+	return []byte{}
 }
 
 func defaultPSKID(suite CipherSuite) []byte {
 	return []byte{}
 }
 
-func verifyMode(suite CipherSuite, mode HPKEMode, psk, pskID, pkSm []byte) error {
-	defaultPKIm := defaultPKIm(suite)
-	defaultPSK := defaultPSK(suite)
-	defaultPSKID := defaultPSKID(suite)
-
-	gotPKIm := !bytes.Equal(pkSm, defaultPKIm)
-	noPKIm := bytes.Equal(pkSm, defaultPKIm)
-	gotPSK := !bytes.Equal(psk, defaultPSK) && !bytes.Equal(pskID, defaultPSKID)
-	noPSK := bytes.Equal(psk, defaultPSK) && bytes.Equal(pskID, defaultPSKID)
+// minPSKSize is the minimum PSK length, since RFC 9180, Section 5.1.2
+// requires at least 32 bytes of entropy.
+const minPSKSize = 32
+
+// verifyPSKInputs implements VerifyPSKInputs of RFC 9180, Section 5.1. A PSK
+// is given if psk is not nil, so that an empty or all-zero PSK is never taken
+// for the default one, and a PSK ID is given if it is not empty. The minimum
+// PSK size is not enforced for VersionDraft, which predates it.
+func verifyPSKInputs(suite CipherSuite, mode HPKEMode, psk, pskID []byte) error {
+	gotPSK := psk != nil
+	gotPSKID := len(pskID) > 0
+	switch {
+	case gotPSK && !gotPSKID:
+		return fmt.Errorf("%w: %w", ErrInvalidPSKConfig, ErrPSKWithoutID)
+	case !gotPSK && gotPSKID:
+		return fmt.Errorf("%w: %w", ErrInvalidPSKConfig, ErrPSKIDWithoutPSK)
+	}
 
-	ok := false
 	switch mode {
-	case modeBase:
-		ok = noPKIm && noPSK
-	case modePSK:
-		ok = noPKIm && gotPSK
-	case modeAuth:
-		ok = gotPKIm && noPSK
-	case modeAuthPSK:
-		ok = gotPKIm && gotPSK
+	case ModeBase, ModeAuth:
+		if gotPSK {
+			return fmt.Errorf("%w: %w", ErrInvalidPSKConfig, ErrUnexpectedPSK)
+		}
+	case ModePSK, ModeAuthPSK:
+		if !gotPSK {
+			return fmt.Errorf("%w: %w", ErrInvalidPSKConfig, ErrMissingPSK)
+		}
+	default:
+		return fmt.Errorf("Unknown mode: %d", mode)
 	}
 
-	if !ok {
-		return fmt.Errorf("Invalid configuration [%d] [%v] [%v]", mode, gotPKIm, gotPSK)
+	if gotPSK && suite.Version == VersionRFC9180 && len(psk) < minPSKSize {
+		return fmt.Errorf("%w: %w: got %d bytes", ErrInvalidPSKConfig, ErrPSKTooShort, len(psk))
 	}
 
 	return nil
 }
 
 type hpkeContext struct {
+	mode      HPKEMode
+	pskIDHash []byte `tls:"head=none"`
+	infoHash  []byte `tls:"head=none"`
+}
+
+type draftHPKEContext struct {
 	kemID     KEMID
 	kdfID     KDFID
 	aeadID    AEADID
@@ -146,40 +347,79 @@ type contextParameters struct {
 	secret             []byte
 }
 
-func (cp contextParameters) aeadKey() []byte {
-	return cp.suite.KDF.LabeledExpand(cp.secret, "key", cp.keyScheduleContext, cp.suite.AEAD.KeySize())
+func (cp contextParameters) aeadKey() ([]byte, error) {
+	return cp.suite.labeledExpand(cp.secret, "key", cp.keyScheduleContext, cp.suite.AEAD.KeySize())
 }
 
-func (cp contextParameters) exporterSecret() []byte {
-	return cp.suite.KDF.LabeledExpand(cp.secret, "exp", cp.keyScheduleContext, cp.suite.KDF.OutputSize())
+func (cp contextParameters) exporterSecret() ([]byte, error) {
+	return cp.suite.labeledExpand(cp.secret, "exp", cp.keyScheduleContext, cp.suite.KDF.OutputSize())
 }
 
-func (cp contextParameters) aeadNonce() []byte {
-	return cp.suite.KDF.LabeledExpand(cp.secret, "nonce", cp.keyScheduleContext, cp.suite.AEAD.NonceSize())
+func (cp contextParameters) aeadNonce() ([]byte, error) {
+	return cp.suite.labeledExpand(cp.secret, "base_nonce", cp.keyScheduleContext, cp.suite.AEAD.NonceSize())
 }
 
-type setupParameters struct {
-	zz  []byte
-	enc []byte
+func (cp contextParameters) export(exporterContext []byte, L int) ([]byte, error) {
+	exporterSecret, err := cp.exporterSecret()
+	if err != nil {
+		return nil, err
+	}
+	return cp.suite.labeledExpand(exporterSecret, "sec", exporterContext, L)
 }
 
-func keySchedule(suite CipherSuite, mode HPKEMode, zz, info, psk, pskID, pkSm []byte) (contextParameters, error) {
-	err := verifyMode(suite, mode, psk, pskID, pkSm)
-	if err != nil {
-		return contextParameters{}, err
+// checkExportLength enforces the limit of the exporter interface of RFC 9180,
+// Section 5.3, which is that of HKDF-Expand.
+func checkExportLength(kdf KDFScheme, L int) error {
+	if L < 0 {
+		return fmt.Errorf("Invalid export length: %d", L)
 	}
+	if L > 255*kdf.OutputSize() {
+		return fmt.Errorf("%w: %d > %d", ErrExportLengthTooLarge, L, 255*kdf.OutputSize())
+	}
+	return nil
+}
 
-	pskIDHash := suite.KDF.LabeledExtract(nil, "pskID_hash", pskID)
-	infoHash := suite.KDF.LabeledExtract(nil, "info_hash", info)
+type setupParameters struct {
+	sharedSecret []byte
+	enc          []byte
+}
 
-	contextStruct := hpkeContext{suite.KEM.ID(), suite.KDF.ID(), suite.AEAD.ID(), mode, pskIDHash, infoHash}
-	keyScheduleContext, err := syntax.Marshal(contextStruct)
+// keySchedule takes nil psk and pskID in the modes without a PSK, and uses
+// the default ones in their place.
+func keySchedule(suite CipherSuite, mode HPKEMode, sharedSecret, info, psk, pskID []byte) (contextParameters, error) {
+	err := verifyPSKInputs(suite, mode, psk, pskID)
 	if err != nil {
 		return contextParameters{}, err
 	}
 
-	psk_hash := suite.KDF.LabeledExtract(nil, "psk_hash", psk)
-	secret := suite.KDF.LabeledExtract(psk_hash, "secret", zz)
+	if psk == nil {
+		psk = defaultPSK(suite)
+		pskID = defaultPSKID(suite)
+	}
+
+	pskIDHash := suite.labeledExtract(nil, "psk_id_hash", pskID)
+	infoHash := suite.labeledExtract(nil, "info_hash", info)
+
+	var keyScheduleContext, secret []byte
+	switch suite.Version {
+	case VersionDraft:
+		contextStruct := draftHPKEContext{suite.KEM.ID(), suite.KDF.ID(), suite.AEAD.ID(), mode, pskIDHash, infoHash}
+		keyScheduleContext, err = syntax.Marshal(contextStruct)
+		if err != nil {
+			return contextParameters{}, err
+		}
+
+		pskHash := suite.labeledExtract(nil, "psk_hash", psk)
+		secret = suite.labeledExtract(pskHash, "secret", sharedSecret)
+	default:
+		contextStruct := hpkeContext{mode, pskIDHash, infoHash}
+		keyScheduleContext, err = syntax.Marshal(contextStruct)
+		if err != nil {
+			return contextParameters{}, err
+		}
+
+		secret = suite.labeledExtract(sharedSecret, "secret", psk)
+	}
 
 	params := contextParameters{
 		suite:              suite,
@@ -197,6 +437,8 @@ type cipherContext struct {
 	aead           cipher.AEAD
 	seq            uint64
 	kdf            KDFScheme
+	suiteID        []byte
+	version        Version
 
 	// Historical record
 	nonces        [][]byte
@@ -205,16 +447,36 @@ type cipherContext struct {
 }
 
 func newCipherContext(suite CipherSuite, setupParams setupParameters, contextParams contextParameters) (cipherContext, error) {
-	key := contextParams.aeadKey()
-	nonce := contextParams.aeadNonce()
-	exporterSecrert := contextParams.exporterSecret()
+	key, err := contextParams.aeadKey()
+	if err != nil {
+		return cipherContext{}, err
+	}
 
-	aead, err := suite.AEAD.New(key)
+	nonce, err := contextParams.aeadNonce()
 	if err != nil {
 		return cipherContext{}, err
 	}
 
-	return cipherContext{key, nonce, exporterSecrert, aead, 0, suite.KDF, nil, setupParams, contextParams}, nil
+	exporterSecrert, err := contextParams.exporterSecret()
+	if err != nil {
+		return cipherContext{}, err
+	}
+
+	// Export-only suites never instantiate an AEAD
+	var aead cipher.AEAD
+	if suite.AEAD.ID() != AEAD_EXPORT_ONLY {
+		aead, err = suite.AEAD.New(key)
+		if err != nil {
+			return cipherContext{}, err
+		}
+
+		// The sequence number is XORed into the last 8 bytes of the nonce
+		if len(nonce) < 8 || aead.NonceSize() != len(nonce) {
+			return cipherContext{}, fmt.Errorf("Invalid nonce size for AEAD %04x: %d", suite.AEAD.ID(), aead.NonceSize())
+		}
+	}
+
+	return cipherContext{key, nonce, exporterSecrert, aead, 0, suite.KDF, suite.suiteID(), suite.Version, nil, setupParams, contextParams}, nil
 }
 
 func (ctx *cipherContext) currNonce() []byte {
@@ -232,15 +494,16 @@ func (ctx *cipherContext) currNonce() []byte {
 	return nonce
 }
 
-func (ctx *cipherContext) incrementSeq() {
-	ctx.seq += 1
-	if ctx.seq == 0 {
-		panic("sequence number wrapped")
+func (ctx *cipherContext) incrementSeq() error {
+	if ctx.seq == math.MaxUint64 {
+		return ErrMessageLimitReached
 	}
+	ctx.seq += 1
+	return nil
 }
 
-func (ctx *cipherContext) Export(context []byte, L int) []byte {
-	return ctx.kdf.LabeledExpand(ctx.exporterSecret, "sec", context, L)
+func (ctx *cipherContext) Export(context []byte, L int) ([]byte, error) {
+	return ctx.version.labeledExpand(ctx.kdf, ctx.exporterSecret, ctx.suiteID, "sec", context, L)
 }
 
 type EncryptContext struct {
@@ -256,10 +519,21 @@ func newEncryptContext(suite CipherSuite, setupParams setupParameters, contextPa
 	return &EncryptContext{ctx}, nil
 }
 
-func (ctx *EncryptContext) Seal(aad, pt []byte) []byte {
+func (ctx *EncryptContext) Seal(aad, pt []byte) ([]byte, error) {
+	if ctx.aead == nil {
+		return nil, ErrExportOnly
+	}
+
+	// AEADs such as CCM panic on messages longer than they can encrypt
+	if limited, ok := ctx.aead.(interface{ maxMessageSize() int }); ok && len(pt) > limited.maxMessageSize() {
+		return nil, fmt.Errorf("Message too long: %d > %d", len(pt), limited.maxMessageSize())
+	}
+
 	ct := ctx.aead.Seal(nil, ctx.currNonce(), pt, aad)
-	ctx.incrementSeq()
-	return ct
+	if err := ctx.incrementSeq(); err != nil {
+		return nil, err
+	}
+	return ct, nil
 }
 
 type DecryptContext struct {
@@ -276,35 +550,191 @@ func newDecryptContext(suite CipherSuite, setupParams setupParameters, contextPa
 }
 
 func (ctx *DecryptContext) Open(aad, ct []byte) ([]byte, error) {
+	if ctx.aead == nil {
+		return nil, ErrExportOnly
+	}
+
 	pt, err := ctx.aead.Open(nil, ctx.currNonce(), ct, aad)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrOpen, err)
 	}
 
-	ctx.incrementSeq()
+	if err := ctx.incrementSeq(); err != nil {
+		return nil, err
+	}
 	return pt, nil
 }
 
-func (ctx *DecryptContext) Export(context []byte, L int) []byte {
-	return ctx.kdf.LabeledExpand(ctx.exporterSecret, "sec", context, L)
+////////////////
+// Unified setup
+
+// SetupOption configures SetupSender and SetupRecipient.
+type SetupOption func(*setupConfig)
+
+type setupConfig struct {
+	rand   io.Reader
+	info   []byte
+	psk    []byte
+	pskID  []byte
+	hasPSK bool
+	skS    KEMPrivateKey
+	pkS    KEMPublicKey
+	auth   bool
 }
 
-///////
-// Base
+// WithInfo sets the application-supplied info.
+func WithInfo(info []byte) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.info = info
+	}
+}
 
-func SetupBaseS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info []byte) ([]byte, *EncryptContext, error) {
-	// zz, enc = Encap(pkR)
-	zz, enc, err := suite.KEM.Encap(rand, pkR)
-	if err != nil {
+// WithPSK selects the PSK or AuthPSK mode, with the given pre-shared key and
+// its identifier.
+func WithPSK(psk, pskID []byte) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.psk = psk
+		cfg.pskID = pskID
+		cfg.hasPSK = true
+	}
+}
+
+// WithSenderKey selects the Auth or AuthPSK mode on the sender's side, with
+// skS as the sender's private key.
+func WithSenderKey(skS KEMPrivateKey) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.skS = skS
+		cfg.auth = true
+	}
+}
+
+// WithSenderPublicKey selects the Auth or AuthPSK mode on the recipient's
+// side, with pkS as the sender's public key.
+func WithSenderPublicKey(pkS KEMPublicKey) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.pkS = pkS
+		cfg.auth = true
+	}
+}
+
+// WithRand sets the source of randomness of the sender, which defaults to
+// crypto/rand.Reader.
+func WithRand(rand io.Reader) SetupOption {
+	return func(cfg *setupConfig) {
+		cfg.rand = rand
+	}
+}
+
+func newSetupConfig(opts []SetupOption) setupConfig {
+	cfg := setupConfig{rand: cryptorand.Reader}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func (cfg setupConfig) mode() HPKEMode {
+	switch {
+	case cfg.auth && cfg.hasPSK:
+		return ModeAuthPSK
+	case cfg.auth:
+		return ModeAuth
+	case cfg.hasPSK:
+		return ModePSK
+	}
+	return ModeBase
+}
+
+// SetupSender encapsulates to pkR and returns the encapsulated key with the
+// sender's context. The mode follows from the options: WithPSK selects PSK
+// mode, WithSenderKey selects Auth mode, and both select AuthPSK mode.
+func SetupSender(suite CipherSuite, pkR KEMPublicKey, opts ...SetupOption) ([]byte, *EncryptContext, error) {
+	cfg := newSetupConfig(opts)
+	if cfg.pkS != nil {
+		return nil, nil, fmt.Errorf("WithSenderPublicKey is a recipient option")
+	}
+
+	mode := cfg.mode()
+	if err := verifyPSKInputs(suite, mode, cfg.psk, cfg.pskID); err != nil {
 		return nil, nil, err
 	}
 
+	var sharedSecret, enc []byte
+	if cfg.auth {
+		// shared_secret, enc = AuthEncap(pkR, skS)
+		auth, err := authKEM(suite)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sharedSecret, enc, err = auth.AuthEncap(cfg.rand, pkR, cfg.skS)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		// shared_secret, enc = Encap(pkR)
+		var err error
+		sharedSecret, enc, err = suite.KEM.Encap(cfg.rand, pkR)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return setupS(suite, mode, sharedSecret, enc, cfg.info, cfg.psk, cfg.pskID)
+}
+
+// SetupRecipient decapsulates enc with skR and returns the recipient's
+// context. It takes the same options as SetupSender, except that the sender
+// is identified by WithSenderPublicKey.
+func SetupRecipient(suite CipherSuite, skR KEMPrivateKey, enc []byte, opts ...SetupOption) (*DecryptContext, error) {
+	cfg := newSetupConfig(opts)
+	if cfg.skS != nil {
+		return nil, fmt.Errorf("WithSenderKey is a sender option")
+	}
+
+	mode := cfg.mode()
+	if err := verifyPSKInputs(suite, mode, cfg.psk, cfg.pskID); err != nil {
+		return nil, err
+	}
+
+	var sharedSecret []byte
+	if cfg.auth {
+		// shared_secret = AuthDecap(enc, skR, pkS)
+		auth, err := authKEM(suite)
+		if err != nil {
+			return nil, err
+		}
+
+		sharedSecret, err = auth.AuthDecap(enc, skR, cfg.pkS)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// shared_secret = Decap(enc, skR)
+		var err error
+		sharedSecret, err = suite.KEM.Decap(enc, skR)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return setupR(suite, mode, sharedSecret, enc, cfg.info, cfg.psk, cfg.pskID)
+}
+
+func authKEM(suite CipherSuite) (AuthKEMScheme, error) {
+	if !suite.SupportsMode(ModeAuth) {
+		return nil, fmt.Errorf("%w: %04x", ErrAuthNotSupported, suite.KEM.ID())
+	}
+	return suite.KEM.(AuthKEMScheme), nil
+}
+
+func setupS(suite CipherSuite, mode HPKEMode, sharedSecret, enc, info, psk, pskID []byte) ([]byte, *EncryptContext, error) {
 	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+		sharedSecret: sharedSecret,
+		enc:          enc,
 	}
 
-	params, err := keySchedule(suite, modeBase, zz, info, defaultPSK(suite), defaultPSKID(suite), defaultPKIm(suite))
+	params, err := keySchedule(suite, mode, sharedSecret, info, psk, pskID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -313,19 +743,13 @@ func SetupBaseS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info []byte
 	return enc, ctx, err
 }
 
-func SetupBaseR(suite CipherSuite, skR KEMPrivateKey, enc, info []byte) (*DecryptContext, error) {
-	// zz = Decap(enc, skR)
-	zz, err := suite.KEM.Decap(enc, skR)
-	if err != nil {
-		return nil, err
-	}
-
+func setupR(suite CipherSuite, mode HPKEMode, sharedSecret, enc, info, psk, pskID []byte) (*DecryptContext, error) {
 	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+		sharedSecret: sharedSecret,
+		enc:          enc,
 	}
 
-	params, err := keySchedule(suite, modeBase, zz, info, defaultPSK(suite), defaultPSKID(suite), defaultPKIm(suite))
+	params, err := keySchedule(suite, mode, sharedSecret, info, psk, pskID)
 	if err != nil {
 		return nil, err
 	}
@@ -333,142 +757,529 @@ func SetupBaseR(suite CipherSuite, skR KEMPrivateKey, enc, info []byte) (*Decryp
 	return newDecryptContext(suite, setupParams, params)
 }
 
+///////
+// Base
+
+func SetupBaseS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info []byte) ([]byte, *EncryptContext, error) {
+	return SetupSender(suite, pkR, WithRand(rand), WithInfo(info))
+}
+
+func SetupBaseR(suite CipherSuite, skR KEMPrivateKey, enc, info []byte) (*DecryptContext, error) {
+	return SetupRecipient(suite, skR, enc, WithInfo(info))
+}
+
 //////
 // PSK
 
 func SetupPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, psk, pskID, info []byte) ([]byte, *EncryptContext, error) {
-	// zz, enc = Encap(pkR)
-	zz, enc, err := suite.KEM.Encap(rand, pkR)
+	return SetupSender(suite, pkR, WithRand(rand), WithPSK(psk, pskID), WithInfo(info))
+}
+
+func SetupPSKR(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info []byte) (*DecryptContext, error) {
+	return SetupRecipient(suite, skR, enc, WithPSK(psk, pskID), WithInfo(info))
+}
+
+///////
+// Auth
+
+func SetupAuthS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info []byte) ([]byte, *EncryptContext, error) {
+	return SetupSender(suite, pkR, WithRand(rand), WithSenderKey(skS), WithInfo(info))
+}
+
+func SetupAuthR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info []byte) (*DecryptContext, error) {
+	return SetupRecipient(suite, skR, enc, WithSenderPublicKey(pkS), WithInfo(info))
+}
+
+/////////////
+// PSK + Auth
+
+func SetupAuthPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info []byte) ([]byte, *EncryptContext, error) {
+	return SetupSender(suite, pkR, WithRand(rand), WithSenderKey(skS), WithPSK(psk, pskID), WithInfo(info))
+}
+
+func SetupAuthPSKR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info []byte) (*DecryptContext, error) {
+	return SetupRecipient(suite, skR, enc, WithSenderPublicKey(pkS), WithPSK(psk, pskID), WithInfo(info))
+}
+
+/////////////////////////
+// Signed sender authentication
+
+// The SetupSignedX functions authenticate the sender with a signature rather
+// than with AuthEncap, so that sender authentication is also available with
+// KEMs that have no Auth mode, such as ML-KEM. The sender signs the suite ID,
+// the mode, enc, the recipient's public key and info with signer, so that a
+// signature cannot be replayed to another recipient or in another mode. The
+// signature is bound into the key schedule by prepending it to info, and the
+// recipient verifies it with the sender's public key pkS before deriving the
+// context.  The key schedule otherwise runs in Base or PSK mode, so peers
+// must agree out of band that signed setup is used.
+//
+// Ed25519, ECDSA with the NIST curves and the circl signature schemes, e.g.,
+// ML-DSA, are supported.
+
+func signedInfo(info, sig []byte) []byte {
+	out := make([]byte, 2, 2+len(sig)+len(info))
+	binary.BigEndian.PutUint16(out, uint16(len(sig)))
+	out = append(out, sig...)
+	return append(out, info...)
+}
+
+func (suite CipherSuite) signatureInput(mode HPKEMode, enc []byte, pkR KEMPublicKey, info []byte) ([]byte, error) {
+	pkRm, err := suite.KEM.Marshal(pkR)
+	if err != nil {
+		return nil, err
+	}
+
+	suiteID := suite.suiteID()
+	out := make([]byte, 0, len(rfcLabel)+len(suiteID)+len("sender_sig")+1+2+len(enc)+2+len(pkRm)+len(info))
+	out = append(out, rfcLabel...)
+	out = append(out, suiteID...)
+	out = append(out, "sender_sig"...)
+	out = append(out, byte(mode))
+	out = binary.BigEndian.AppendUint16(out, uint16(len(enc)))
+	out = append(out, enc...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(pkRm)))
+	out = append(out, pkRm...)
+	return append(out, info...), nil
+}
+
+func setupSignedS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, signer crypto.Signer, mode HPKEMode, psk, pskID, info []byte) ([]byte, []byte, *EncryptContext, error) {
+	sharedSecret, enc, err := suite.KEM.Encap(rand, pkR)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	message, err := suite.signatureInput(mode, enc, pkR, info)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	sig, err := signSender(rand, signer, message)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if len(sig) > math.MaxUint16 {
+		return nil, nil, nil, fmt.Errorf("Signature too long: %d", len(sig))
+	}
+
+	_, ctx, err := setupS(suite, mode, sharedSecret, enc, signedInfo(info, sig), psk, pskID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return enc, sig, ctx, nil
+}
+
+func setupSignedR(suite CipherSuite, skR KEMPrivateKey, pkS crypto.PublicKey, mode HPKEMode, enc, sig, psk, pskID, info []byte) (*DecryptContext, error) {
+	if len(sig) > math.MaxUint16 {
+		return nil, ErrBadSignature
+	}
+
+	message, err := suite.signatureInput(mode, enc, skR.PublicKey(), info)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := verifySender(pkS, message, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, ErrBadSignature
+	}
+
+	sharedSecret, err := suite.KEM.Decap(enc, skR)
+	if err != nil {
+		return nil, err
+	}
+
+	return setupR(suite, mode, sharedSecret, enc, signedInfo(info, sig), psk, pskID)
+}
+
+// SetupSignedS returns the encapsulated key, the sender's signature and the
+// context. Both enc and sig must be sent to the recipient.
+func SetupSignedS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, signer crypto.Signer, info []byte) ([]byte, []byte, *EncryptContext, error) {
+	return setupSignedS(suite, rand, pkR, signer, ModeBase, nil, nil, info)
+}
+
+func SetupSignedR(suite CipherSuite, skR KEMPrivateKey, pkS crypto.PublicKey, enc, sig, info []byte) (*DecryptContext, error) {
+	return setupSignedR(suite, skR, pkS, ModeBase, enc, sig, nil, nil, info)
+}
+
+func SetupSignedPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, signer crypto.Signer, psk, pskID, info []byte) ([]byte, []byte, *EncryptContext, error) {
+	return setupSignedS(suite, rand, pkR, signer, ModePSK, psk, pskID, info)
+}
+
+func SetupSignedPSKR(suite CipherSuite, skR KEMPrivateKey, pkS crypto.PublicKey, enc, sig, psk, pskID, info []byte) (*DecryptContext, error) {
+	return setupSignedR(suite, skR, pkS, ModePSK, enc, sig, psk, pskID, info)
+}
+
+////////////////////
+// Deterministic setup
+
+// The SetupXSDeterministic functions are the counterparts of the SetupXS
+// functions that take the sender's randomness from ikmE instead of a random
+// source; see DeterministicKEMScheme.  They must only be used with ikmE that
+// is secret and never reused, or for testing.
+
+func deterministicKEM(suite CipherSuite) (DeterministicKEMScheme, error) {
+	kem, ok := suite.KEM.(DeterministicKEMScheme)
+	if !ok {
+		return nil, fmt.Errorf("KEM %04x does not support deterministic encapsulation", suite.KEM.ID())
+	}
+	return kem, nil
+}
+
+func deterministicAuthKEM(suite CipherSuite) (DeterministicAuthKEMScheme, error) {
+	kem, ok := suite.KEM.(DeterministicAuthKEMScheme)
+	if !ok {
+		if !suite.SupportsMode(ModeAuth) {
+			return nil, fmt.Errorf("%w: %04x", ErrAuthNotSupported, suite.KEM.ID())
+		}
+		return nil, fmt.Errorf("KEM %04x does not support deterministic authenticated encapsulation", suite.KEM.ID())
+	}
+	return kem, nil
+}
+
+func SetupBaseSDeterministic(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, info []byte) ([]byte, *EncryptContext, error) {
+	kem, err := deterministicKEM(suite)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+	sharedSecret, enc, err := kem.EncapDeterministic(ikmE, pkR)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	params, err := keySchedule(suite, modePSK, zz, info, psk, pskID, defaultPKIm(suite))
+	return setupS(suite, ModeBase, sharedSecret, enc, info, nil, nil)
+}
+
+func SetupPSKSDeterministic(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, psk, pskID, info []byte) ([]byte, *EncryptContext, error) {
+	kem, err := deterministicKEM(suite)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	ctx, err := newEncryptContext(suite, setupParams, params)
-	return enc, ctx, err
+	sharedSecret, enc, err := kem.EncapDeterministic(ikmE, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return setupS(suite, ModePSK, sharedSecret, enc, info, psk, pskID)
 }
 
-func SetupPSKR(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info []byte) (*DecryptContext, error) {
-	// zz = Decap(enc, skR)
-	zz, err := suite.KEM.Decap(enc, skR)
+func SetupAuthSDeterministic(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, skS KEMPrivateKey, info []byte) ([]byte, *EncryptContext, error) {
+	kem, err := deterministicAuthKEM(suite)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedSecret, enc, err := kem.AuthEncapDeterministic(ikmE, pkR, skS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return setupS(suite, ModeAuth, sharedSecret, enc, info, nil, nil)
+}
+
+func SetupAuthPSKSDeterministic(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info []byte) ([]byte, *EncryptContext, error) {
+	kem, err := deterministicAuthKEM(suite)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedSecret, enc, err := kem.AuthEncapDeterministic(ikmE, pkR, skS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return setupS(suite, ModeAuthPSK, sharedSecret, enc, info, psk, pskID)
+}
+
+/////////////////
+// Single-shot APIs
+
+func SealBase(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupBaseS(suite, rand, pkR, info)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := ctx.Seal(aad, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ct, nil
+}
+
+func OpenBase(suite CipherSuite, skR KEMPrivateKey, enc, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupBaseR(suite, skR, enc, info)
 	if err != nil {
 		return nil, err
 	}
 
-	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+	return ctx.Open(aad, ct)
+}
+
+func SealPSK(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, psk, pskID, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupPSKS(suite, rand, pkR, psk, pskID, info)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	params, err := keySchedule(suite, modePSK, zz, info, psk, pskID, defaultPKIm(suite))
+	ct, err := ctx.Seal(aad, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ct, nil
+}
+
+func OpenPSK(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupPSKR(suite, skR, enc, psk, pskID, info)
 	if err != nil {
 		return nil, err
 	}
 
-	return newDecryptContext(suite, setupParams, params)
+	return ctx.Open(aad, ct)
 }
 
-///////
-// Auth
+func SealAuth(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupAuthS(suite, rand, pkR, skS, info)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func SetupAuthS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info []byte) ([]byte, *EncryptContext, error) {
-	// zz, enc = AuthEncap(pkR, skS)
-	auth := suite.KEM.(AuthKEMScheme)
-	zz, enc, err := auth.AuthEncap(rand, pkR, skS)
+	ct, err := ctx.Seal(aad, pt)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+	return enc, ct, nil
+}
+
+func OpenAuth(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupAuthR(suite, skR, pkS, enc, info)
+	if err != nil {
+		return nil, err
 	}
 
-	pkSm := suite.KEM.Marshal(skS.PublicKey())
-	params, err := keySchedule(suite, modeAuth, zz, info, defaultPSK(suite), defaultPSKID(suite), pkSm)
+	return ctx.Open(aad, ct)
+}
+
+func SealAuthPSK(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info, aad, pt []byte) ([]byte, []byte, error) {
+	enc, ctx, err := SetupAuthPSKS(suite, rand, pkR, skS, psk, pskID, info)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	ctx, err := newEncryptContext(suite, setupParams, params)
-	return enc, ctx, err
+	ct, err := ctx.Seal(aad, pt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, ct, nil
 }
 
-func SetupAuthR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info []byte) (*DecryptContext, error) {
-	// zz = AuthDecap(enc, skR, pkS)
-	auth := suite.KEM.(AuthKEMScheme)
-	zz, err := auth.AuthDecap(enc, skR, pkS)
+func OpenAuthPSK(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info, aad, ct []byte) ([]byte, error) {
+	ctx, err := SetupAuthPSKR(suite, skR, pkS, enc, psk, pskID, info)
 	if err != nil {
 		return nil, err
 	}
 
-	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+	return ctx.Open(aad, ct)
+}
+
+/////////////////////////
+// Migration between ciphersuites
+
+// ResealBase opens a ciphertext sealed in base mode under oldSuite with skR
+// and seals the recovered plaintext in base mode to pkR under newSuite. It is
+// intended for moving data off a withdrawn KEM while the old private key is
+// still available. The same info and aad are used for both operations.
+func ResealBase(oldSuite CipherSuite, skR KEMPrivateKey, enc, info, aad, ct []byte, newSuite CipherSuite, rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	pt, err := OpenBase(oldSuite, skR, enc, info, aad, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return SealBase(newSuite, rand, pkR, info, aad, pt)
+}
+
+// ResealPSK is the PSK mode counterpart of ResealBase. The same PSK is used
+// to open and to reseal the data.
+func ResealPSK(oldSuite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info, aad, ct []byte, newSuite CipherSuite, rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	pt, err := OpenPSK(oldSuite, skR, enc, psk, pskID, info, aad, ct)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return SealPSK(newSuite, rand, pkR, psk, pskID, info, aad, pt)
+}
+
+/////////////////////////
+// Single-shot secret export
+
+func SendExportBase(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, info, exporterContext []byte, L int) ([]byte, []byte, error) {
+	// shared_secret, enc = Encap(pkR)
+	sharedSecret, enc, err := suite.KEM.Encap(rand, pkR)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	pkSm := suite.KEM.Marshal(pkS)
-	params, err := keySchedule(suite, modeAuth, zz, info, defaultPSK(suite), defaultPSKID(suite), pkSm)
+	params, err := keySchedule(suite, ModeBase, sharedSecret, info, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exported, err := params.export(exporterContext, L)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, exported, nil
+}
+
+func ReceiveExportBase(suite CipherSuite, skR KEMPrivateKey, enc, info, exporterContext []byte, L int) ([]byte, error) {
+	// shared_secret = Decap(enc, skR)
+	sharedSecret, err := suite.KEM.Decap(enc, skR)
 	if err != nil {
 		return nil, err
 	}
 
-	return newDecryptContext(suite, setupParams, params)
+	params, err := keySchedule(suite, ModeBase, sharedSecret, info, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return params.export(exporterContext, L)
 }
 
-/////////////
-// PSK + Auth
+func SendExportPSK(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, psk, pskID, info, exporterContext []byte, L int) ([]byte, []byte, error) {
+	// shared_secret, enc = Encap(pkR)
+	sharedSecret, enc, err := suite.KEM.Encap(rand, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func SetupAuthPSKS(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info []byte) ([]byte, *EncryptContext, error) {
-	// zz, enc = AuthEncap(pkR, skS)
-	auth := suite.KEM.(AuthKEMScheme)
-	zz, enc, err := auth.AuthEncap(rand, pkR, skS)
+	params, err := keySchedule(suite, ModePSK, sharedSecret, info, psk, pskID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+	exported, err := params.export(exporterContext, L)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, exported, nil
+}
+
+func ReceiveExportPSK(suite CipherSuite, skR KEMPrivateKey, enc, psk, pskID, info, exporterContext []byte, L int) ([]byte, error) {
+	// shared_secret = Decap(enc, skR)
+	sharedSecret, err := suite.KEM.Decap(enc, skR)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := keySchedule(suite, ModePSK, sharedSecret, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return params.export(exporterContext, L)
+}
+
+func SendExportAuth(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, info, exporterContext []byte, L int) ([]byte, []byte, error) {
+	// shared_secret, enc = AuthEncap(pkR, skS)
+	auth, err := authKEM(suite)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	pkSm := suite.KEM.Marshal(skS.PublicKey())
-	params, err := keySchedule(suite, modeAuthPSK, zz, info, psk, pskID, pkSm)
+	sharedSecret, enc, err := auth.AuthEncap(rand, pkR, skS)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	ctx, err := newEncryptContext(suite, setupParams, params)
-	return enc, ctx, err
+	params, err := keySchedule(suite, ModeAuth, sharedSecret, info, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exported, err := params.export(exporterContext, L)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, exported, nil
 }
 
-func SetupAuthPSKR(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info []byte) (*DecryptContext, error) {
-	// zz = AuthDecap(enc, skR, pkS)
-	auth := suite.KEM.(AuthKEMScheme)
-	zz, err := auth.AuthDecap(enc, skR, pkS)
+func ReceiveExportAuth(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, info, exporterContext []byte, L int) ([]byte, error) {
+	// shared_secret = AuthDecap(enc, skR, pkS)
+	auth, err := authKEM(suite)
 	if err != nil {
 		return nil, err
 	}
 
-	setupParams := setupParameters{
-		zz:  zz,
-		enc: enc,
+	sharedSecret, err := auth.AuthDecap(enc, skR, pkS)
+	if err != nil {
+		return nil, err
 	}
 
-	pkSm := suite.KEM.Marshal(pkS)
-	params, err := keySchedule(suite, modeAuthPSK, zz, info, psk, pskID, pkSm)
+	params, err := keySchedule(suite, ModeAuth, sharedSecret, info, nil, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return newDecryptContext(suite, setupParams, params)
+	return params.export(exporterContext, L)
+}
+
+func SendExportAuthPSK(suite CipherSuite, rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey, psk, pskID, info, exporterContext []byte, L int) ([]byte, []byte, error) {
+	// shared_secret, enc = AuthEncap(pkR, skS)
+	auth, err := authKEM(suite)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sharedSecret, enc, err := auth.AuthEncap(rand, pkR, skS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params, err := keySchedule(suite, ModeAuthPSK, sharedSecret, info, psk, pskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exported, err := params.export(exporterContext, L)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return enc, exported, nil
+}
+
+func ReceiveExportAuthPSK(suite CipherSuite, skR KEMPrivateKey, pkS KEMPublicKey, enc, psk, pskID, info, exporterContext []byte, L int) ([]byte, error) {
+	// shared_secret = AuthDecap(enc, skR, pkS)
+	auth, err := authKEM(suite)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedSecret, err := auth.AuthDecap(enc, skR, pkS)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := keySchedule(suite, ModeAuthPSK, sharedSecret, info, psk, pskID)
+	if err != nil {
+		return nil, err
+	}
+
+	return params.export(exporterContext, L)
 }
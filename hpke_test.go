@@ -774,17 +774,28 @@ package hpke
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+
+	"github.com/cloudflare/circl/sign/mldsa/mldsa65"
 )
 
 var (
-	fixedPSK      = []byte{0x5d, 0xb3, 0xb8, 0x0a, 0x81, 0xcb, 0x63, 0xca, 0x59, 0x47, 0x0c, 0x83, 0x41, 0x4e, 0xf7, 0x0a}
+	fixedPSK      = []byte{0x02, 0x47, 0xfd, 0x33, 0xb9, 0x13, 0x76, 0x0f, 0xa1, 0xfa, 0x51, 0xe1, 0x89, 0x2d, 0x9f, 0x30, 0x7f, 0xbe, 0x65, 0xeb, 0x17, 0x1e, 0x81, 0x32, 0xc2, 0xaf, 0x18, 0x55, 0x5a, 0x73, 0x8b, 0x82}
 	fixedPSKID    = []byte("Ennyn Durin aran Moria")
 	original      = []byte("Beauty is truth, truth beauty")
 	aad           = []byte("that is all // Ye know on earth, and all ye need to know")
@@ -797,6 +808,7 @@ var (
 const (
 	outputTestVectorEnvironmentKey = "HPKE_TEST_VECTORS_OUT"
 	inputTestVectorEnvironmentKey  = "HPKE_TEST_VECTORS_IN"
+	testVectorFilePattern          = "testdata/test-vectors*.json"
 	testVectorEncryptionCount      = 10
 	testVectorExportCount          = 5
 	testVectorExportLength         = 32
@@ -835,7 +847,13 @@ func mustUnmarshalPriv(t *testing.T, suite CipherSuite, h string, required bool)
 }
 
 func mustMarshalPriv(suite CipherSuite, priv KEMPrivateKey) string {
-	return mustHex(suite.KEM.MarshalPrivate(priv))
+	if priv == nil {
+		return ""
+	}
+
+	skm, err := suite.KEM.MarshalPrivate(priv)
+	fatalOnError(nil, err, "MarshalPrivate failed")
+	return mustHex(skm)
 }
 
 func mustUnmarshalPub(t *testing.T, suite CipherSuite, h string, required bool) KEMPublicKey {
@@ -848,13 +866,99 @@ func mustUnmarshalPub(t *testing.T, suite CipherSuite, h string, required bool)
 }
 
 func mustMarshalPub(suite CipherSuite, pub KEMPublicKey) string {
-	return mustHex(suite.KEM.Marshal(pub))
+	if pub == nil {
+		return ""
+	}
+
+	pkm, err := suite.KEM.Marshal(pub)
+	fatalOnError(nil, err, "Marshal failed")
+	return mustHex(pkm)
 }
 
-func mustGenerateKeyPair(t *testing.T, suite CipherSuite) (KEMPrivateKey, KEMPublicKey) {
-	sk, pk, err := suite.KEM.GenerateKeyPair(rand.Reader)
+func mustGenerateKeyPair(t *testing.T, suite CipherSuite) (KEMPrivateKey, KEMPublicKey, []byte) {
+	if _, ok := suite.KEM.(*rsaKEMScheme); ok {
+		return mustGenerateRSAKeyPair(t, suite)
+	}
+
+	ikm := make([]byte, suite.KEM.PrivateKeySize())
+	rand.Reader.Read(ikm)
+	sk, pk, err := suite.KEM.DeriveKeyPair(ikm)
 	fatalOnError(t, err, "Error generating DH key pair")
-	return sk, pk
+	return sk, pk, ikm
+}
+
+// rsaKeyPairs holds a few derived key pairs of each RSA KEM, whose key
+// derivation takes long enough to dominate the tests that range over every
+// KEM. mustGenerateRSAKeyPair hands them out in turn, so that consecutive
+// calls still return distinct key pairs.
+var rsaKeyPairs = struct {
+	sync.Mutex
+	pairs map[KEMID][]rsaKeyPair
+	next  map[KEMID]int
+}{pairs: map[KEMID][]rsaKeyPair{}, next: map[KEMID]int{}}
+
+const rsaKeyPairCount = 3
+
+type rsaKeyPair struct {
+	sk  KEMPrivateKey
+	pk  KEMPublicKey
+	ikm []byte
+}
+
+func mustGenerateRSAKeyPair(t *testing.T, suite CipherSuite) (KEMPrivateKey, KEMPublicKey, []byte) {
+	rsaKeyPairs.Lock()
+	defer rsaKeyPairs.Unlock()
+
+	kemID := suite.KEM.ID()
+	i := rsaKeyPairs.next[kemID]
+	rsaKeyPairs.next[kemID] = (i + 1) % rsaKeyPairCount
+
+	if i == len(rsaKeyPairs.pairs[kemID]) {
+		ikm := make([]byte, suite.KEM.PrivateKeySize())
+		rand.Reader.Read(ikm)
+		sk, pk, err := suite.KEM.DeriveKeyPair(ikm)
+		fatalOnError(t, err, "Error deriving RSA key pair")
+		rsaKeyPairs.pairs[kemID] = append(rsaKeyPairs.pairs[kemID], rsaKeyPair{sk, pk, ikm})
+	}
+
+	pair := rsaKeyPairs.pairs[kemID][i]
+	return pair.sk, pair.pk, pair.ikm
+}
+
+// /////
+// Deterministic encapsulation
+
+// fixedEphemeralKEM replays a fixed ephemeral key pair of a DHKEM. It is only
+// needed for the draft-version test vectors, which carry skEm but no ikmE;
+// all other vectors go through the SetupXSDeterministic functions.
+type fixedEphemeralKEM struct {
+	*dhkemScheme
+	skE KEMPrivateKey
+}
+
+func (s fixedEphemeralKEM) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	return s.encap(s.skE, s.skE.PublicKey(), pkR)
+}
+
+func (s fixedEphemeralKEM) AuthEncap(rand io.Reader, pkR KEMPublicKey, skS KEMPrivateKey) ([]byte, []byte, error) {
+	return s.authEncap(s.skE, s.skE.PublicKey(), pkR, skS)
+}
+
+// hasEphemeralKeyPair reports whether test vectors for the KEM carry an
+// ephemeral key pair, rather than only the encapsulation randomness ikmE.
+func hasEphemeralKeyPair(s KEMScheme) bool {
+	_, ok := s.(*dhkemScheme)
+	return ok
+}
+
+func mustGenerateEphemeral(t *testing.T, suite CipherSuite) (KEMPrivateKey, KEMPublicKey, []byte) {
+	if hasEphemeralKeyPair(suite.KEM) {
+		return mustGenerateKeyPair(t, suite)
+	}
+
+	ikmE := make([]byte, suite.KEM.(DeterministicKEMScheme).EncapSeedSize())
+	rand.Reader.Read(ikmE)
+	return nil, nil, ikmE
 }
 
 // /////
@@ -886,10 +990,10 @@ type encryptionTestVector struct {
 
 func (etv encryptionTestVector) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]string{
-		"plaintext":  mustHex(etv.plaintext),
-		"aad":        mustHex(etv.aad),
-		"nonce":      mustHex(etv.nonce),
-		"ciphertext": mustHex(etv.ciphertext),
+		"pt":    mustHex(etv.plaintext),
+		"aad":   mustHex(etv.aad),
+		"nonce": mustHex(etv.nonce),
+		"ct":    mustHex(etv.ciphertext),
 	})
 }
 
@@ -900,19 +1004,19 @@ func (etv *encryptionTestVector) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
-	etv.plaintext = mustUnhex(nil, raw["plaintext"])
+	etv.plaintext = mustUnhex(nil, raw["pt"])
 	etv.aad = mustUnhex(nil, raw["aad"])
 	etv.nonce = mustUnhex(nil, raw["nonce"])
-	etv.ciphertext = mustUnhex(nil, raw["ciphertext"])
+	etv.ciphertext = mustUnhex(nil, raw["ct"])
 	return nil
 }
 
 // /////
 // Exporter test vector structures
 type rawExporterTestVector struct {
-	ExportContext string `json:"exportContext"`
-	ExportLength  int    `json:"exportLength"`
-	ExportValue   string `json:"exportValue"`
+	ExportContext string `json:"exporter_context"`
+	ExportLength  int    `json:"L"`
+	ExportValue   string `json:"exported_value"`
 }
 
 type exporterTestVector struct {
@@ -946,18 +1050,22 @@ func (etv *exporterTestVector) UnmarshalJSON(data []byte) error {
 // HPKE test vector structures
 type rawTestVector struct {
 	// Parameters
-	Mode   HPKEMode `json:"mode"`
-	KEMID  KEMID    `json:"kemID"`
-	KDFID  KDFID    `json:"kdfID"`
-	AEADID AEADID   `json:"aeadID"`
-	Info   string   `json:"info"`
+	Version Version  `json:"version,omitempty"`
+	Mode    HPKEMode `json:"mode"`
+	KEMID   KEMID    `json:"kem_id"`
+	KDFID   KDFID    `json:"kdf_id"`
+	AEADID  AEADID   `json:"aead_id"`
+	Info    string   `json:"info"`
 
 	// Private keys
+	IKMR  string `json:"ikmR"`
+	IKMS  string `json:"ikmS,omitempty"`
+	IKME  string `json:"ikmE"`
 	SKR   string `json:"skRm"`
 	SKS   string `json:"skSm,omitempty"`
 	SKE   string `json:"skEm"`
 	PSK   string `json:"psk,omitempty"`
-	PSKID string `json:"pskID,omitempty"`
+	PSKID string `json:"psk_id,omitempty"`
 
 	// Public keys
 	PKR string `json:"pkRm"`
@@ -966,12 +1074,12 @@ type rawTestVector struct {
 
 	// Key schedule inputs and computations
 	Enc                string `json:"enc"`
-	Zz                 string `json:"zz"`
+	SharedSecret       string `json:"shared_secret"`
 	KeyScheduleContext string `json:"key_schedule_context"`
 	Secret             string `json:"secret"`
 	Key                string `json:"key"`
-	Nonce              string `json:"nonce"`
-	ExporterSecret     string `json:"exporterSecret"`
+	BaseNonce          string `json:"base_nonce"`
+	ExporterSecret     string `json:"exporter_secret"`
 
 	Encryptions []encryptionTestVector `json:"encryptions"`
 	Exports     []exporterTestVector   `json:"exports"`
@@ -981,17 +1089,24 @@ type testVector struct {
 	t     *testing.T
 	suite CipherSuite
 
+	// Set when the vector's ciphersuite is not implemented by this package
+	unsupported bool
+
 	// Parameters
-	mode   HPKEMode
-	kemID  KEMID
-	kdfID  KDFID
-	aeadID AEADID
-	info   []byte
+	version Version
+	mode    HPKEMode
+	kemID   KEMID
+	kdfID   KDFID
+	aeadID  AEADID
+	info    []byte
 
 	// Private keys
 	skR   KEMPrivateKey
 	skS   KEMPrivateKey
 	skE   KEMPrivateKey
+	ikmR  []byte
+	ikmS  []byte
+	ikmE  []byte
 	psk   []byte
 	pskID []byte
 
@@ -1002,11 +1117,11 @@ type testVector struct {
 
 	// Key schedule inputs and computations
 	enc                []byte
-	zz                 []byte
+	sharedSecret       []byte
 	keyScheduleContext []byte
 	secret             []byte
 	key                []byte
-	nonce              []byte
+	baseNonce          []byte
 	exporterSecret     []byte
 
 	encryptions []encryptionTestVector
@@ -1015,12 +1130,16 @@ type testVector struct {
 
 func (tv testVector) MarshalJSON() ([]byte, error) {
 	return json.Marshal(rawTestVector{
-		Mode:   tv.mode,
-		KEMID:  tv.kemID,
-		KDFID:  tv.kdfID,
-		AEADID: tv.aeadID,
-		Info:   mustHex(tv.info),
+		Version: tv.version,
+		Mode:    tv.mode,
+		KEMID:   tv.kemID,
+		KDFID:   tv.kdfID,
+		AEADID:  tv.aeadID,
+		Info:    mustHex(tv.info),
 
+		IKMR:  mustHex(tv.ikmR),
+		IKMS:  mustHex(tv.ikmS),
+		IKME:  mustHex(tv.ikmE),
 		SKR:   mustMarshalPriv(tv.suite, tv.skR),
 		SKS:   mustMarshalPriv(tv.suite, tv.skS),
 		SKE:   mustMarshalPriv(tv.suite, tv.skE),
@@ -1032,11 +1151,11 @@ func (tv testVector) MarshalJSON() ([]byte, error) {
 		PKE: mustMarshalPub(tv.suite, tv.pkE),
 
 		Enc:                mustHex(tv.enc),
-		Zz:                 mustHex(tv.zz),
+		SharedSecret:       mustHex(tv.sharedSecret),
 		KeyScheduleContext: mustHex(tv.keyScheduleContext),
 		Secret:             mustHex(tv.secret),
 		Key:                mustHex(tv.key),
-		Nonce:              mustHex(tv.nonce),
+		BaseNonce:          mustHex(tv.baseNonce),
 		ExporterSecret:     mustHex(tv.exporterSecret),
 
 		Encryptions: tv.encryptions,
@@ -1051,36 +1170,40 @@ func (tv *testVector) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	tv.version = raw.Version
 	tv.mode = raw.Mode
 	tv.kemID = raw.KEMID
 	tv.kdfID = raw.KDFID
 	tv.aeadID = raw.AEADID
 	tv.info = mustUnhex(tv.t, raw.Info)
 
-	tv.suite, err = AssembleCipherSuite(raw.KEMID, raw.KDFID, raw.AEADID)
+	tv.suite, err = AssembleVersionedCipherSuite(raw.Version, raw.KEMID, raw.KDFID, raw.AEADID)
 	if err != nil {
-		return err
+		tv.unsupported = true
+		return nil
 	}
 
-	modeRequiresSenderKey := (tv.mode == modeAuth || tv.mode == modeAuthPSK)
+	modeRequiresSenderKey := (tv.mode == ModeAuth || tv.mode == ModeAuthPSK)
+	ephemeral := hasEphemeralKeyPair(tv.suite.KEM)
 	tv.skR = mustUnmarshalPriv(tv.t, tv.suite, raw.SKR, true)
 	tv.skS = mustUnmarshalPriv(tv.t, tv.suite, raw.SKS, modeRequiresSenderKey)
-	tv.skE = mustUnmarshalPriv(tv.t, tv.suite, raw.SKE, true)
+	tv.skE = mustUnmarshalPriv(tv.t, tv.suite, raw.SKE, ephemeral)
+	tv.ikmR = mustUnhex(tv.t, raw.IKMR)
+	tv.ikmS = mustUnhex(tv.t, raw.IKMS)
+	tv.ikmE = mustUnhex(tv.t, raw.IKME)
 	tv.psk = mustUnhex(tv.t, raw.PSK)
 	tv.pskID = mustUnhex(tv.t, raw.PSKID)
 
-	tv.suite.KEM.setEphemeralKeyPair(tv.skE)
-
 	tv.pkR = mustUnmarshalPub(tv.t, tv.suite, raw.PKR, true)
 	tv.pkS = mustUnmarshalPub(tv.t, tv.suite, raw.PKI, modeRequiresSenderKey)
-	tv.pkE = mustUnmarshalPub(tv.t, tv.suite, raw.PKE, true)
+	tv.pkE = mustUnmarshalPub(tv.t, tv.suite, raw.PKE, ephemeral)
 
 	tv.enc = mustUnhex(tv.t, raw.Enc)
-	tv.zz = mustUnhex(tv.t, raw.Zz)
+	tv.sharedSecret = mustUnhex(tv.t, raw.SharedSecret)
 	tv.keyScheduleContext = mustUnhex(tv.t, raw.KeyScheduleContext)
 	tv.secret = mustUnhex(tv.t, raw.Secret)
 	tv.key = mustUnhex(tv.t, raw.Key)
-	tv.nonce = mustUnhex(tv.t, raw.Nonce)
+	tv.baseNonce = mustUnhex(tv.t, raw.BaseNonce)
 	tv.exporterSecret = mustUnhex(tv.t, raw.ExporterSecret)
 
 	tv.encryptions = raw.Encryptions
@@ -1115,32 +1238,46 @@ type setupMode struct {
 	Mode HPKEMode
 	OK   func(suite CipherSuite) bool
 	I    func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error)
+	ID   func(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error)
 	R    func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID []byte) (*DecryptContext, error)
+	RX   func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID, exporterContext []byte, L int) ([]byte, error)
 }
 
 var setupModes = map[HPKEMode]setupMode{
-	modeBase: {
-		Mode: modeBase,
+	ModeBase: {
+		Mode: ModeBase,
 		OK:   func(suite CipherSuite) bool { return true },
 		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
 			return SetupBaseS(suite, rand.Reader, pkR, info)
 		},
+		ID: func(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
+			return SetupBaseSDeterministic(suite, ikmE, pkR, info)
+		},
 		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID []byte) (*DecryptContext, error) {
 			return SetupBaseR(suite, skR, enc, info)
 		},
+		RX: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID, exporterContext []byte, L int) ([]byte, error) {
+			return ReceiveExportBase(suite, skR, enc, info, exporterContext, L)
+		},
 	},
-	modePSK: {
-		Mode: modePSK,
+	ModePSK: {
+		Mode: ModePSK,
 		OK:   func(suite CipherSuite) bool { return true },
 		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
 			return SetupPSKS(suite, rand.Reader, pkR, psk, pskID, info)
 		},
+		ID: func(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
+			return SetupPSKSDeterministic(suite, ikmE, pkR, psk, pskID, info)
+		},
 		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID []byte) (*DecryptContext, error) {
 			return SetupPSKR(suite, skR, enc, psk, pskID, info)
 		},
+		RX: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID, exporterContext []byte, L int) ([]byte, error) {
+			return ReceiveExportPSK(suite, skR, enc, psk, pskID, info, exporterContext, L)
+		},
 	},
-	modeAuth: {
-		Mode: modeAuth,
+	ModeAuth: {
+		Mode: ModeAuth,
 		OK: func(suite CipherSuite) bool {
 			_, ok := suite.KEM.(AuthKEMScheme)
 			return ok
@@ -1148,12 +1285,18 @@ var setupModes = map[HPKEMode]setupMode{
 		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
 			return SetupAuthS(suite, rand.Reader, pkR, skS, info)
 		},
+		ID: func(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
+			return SetupAuthSDeterministic(suite, ikmE, pkR, skS, info)
+		},
 		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID []byte) (*DecryptContext, error) {
 			return SetupAuthR(suite, skR, pkS, enc, info)
 		},
+		RX: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID, exporterContext []byte, L int) ([]byte, error) {
+			return ReceiveExportAuth(suite, skR, pkS, enc, info, exporterContext, L)
+		},
 	},
-	modeAuthPSK: {
-		Mode: modeAuthPSK,
+	ModeAuthPSK: {
+		Mode: ModeAuthPSK,
 		OK: func(suite CipherSuite) bool {
 			_, ok := suite.KEM.(AuthKEMScheme)
 			return ok
@@ -1161,9 +1304,15 @@ var setupModes = map[HPKEMode]setupMode{
 		I: func(suite CipherSuite, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
 			return SetupAuthPSKS(suite, rand.Reader, pkR, skS, psk, pskID, info)
 		},
+		ID: func(suite CipherSuite, ikmE []byte, pkR KEMPublicKey, info []byte, skS KEMPrivateKey, psk, pskID []byte) ([]byte, *EncryptContext, error) {
+			return SetupAuthPSKSDeterministic(suite, ikmE, pkR, skS, psk, pskID, info)
+		},
 		R: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID []byte) (*DecryptContext, error) {
 			return SetupAuthPSKR(suite, skR, pkS, enc, psk, pskID, info)
 		},
+		RX: func(suite CipherSuite, skR KEMPrivateKey, enc, info []byte, pkS KEMPublicKey, psk, pskID, exporterContext []byte, L int) ([]byte, error) {
+			return ReceiveExportAuthPSK(suite, skR, pkS, enc, psk, pskID, info, exporterContext, L)
+		},
 	},
 }
 
@@ -1171,14 +1320,15 @@ var setupModes = map[HPKEMode]setupMode{
 // Direct tests
 
 type roundTripTest struct {
-	kemID  KEMID
-	kdfID  KDFID
-	aeadID AEADID
-	setup  setupMode
+	version Version
+	kemID   KEMID
+	kdfID   KDFID
+	aeadID  AEADID
+	setup   setupMode
 }
 
 func (rtt roundTripTest) Test(t *testing.T) {
-	suite, err := AssembleCipherSuite(rtt.kemID, rtt.kdfID, rtt.aeadID)
+	suite, err := AssembleVersionedCipherSuite(rtt.version, rtt.kemID, rtt.kdfID, rtt.aeadID)
 	if err != nil {
 		t.Fatalf("[%04x, %04x, %04x] Error looking up ciphersuite: %v", rtt.kemID, rtt.kdfID, rtt.aeadID, err)
 	}
@@ -1187,8 +1337,8 @@ func (rtt roundTripTest) Test(t *testing.T) {
 		return
 	}
 
-	skS, pkS := mustGenerateKeyPair(t, suite)
-	skR, pkR := mustGenerateKeyPair(t, suite)
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
 
 	enc, ctxI, err := rtt.setup.I(suite, pkR, info, skS, fixedPSK, fixedPSKID)
 	assertNotError(t, suite, "Error in SetupI", err)
@@ -1197,39 +1347,623 @@ func (rtt roundTripTest) Test(t *testing.T) {
 	assertNotError(t, suite, "Error in SetupR", err)
 
 	// Verify encryption functionality
-	for range make([]struct{}, rtts) {
-		encrypted := ctxI.Seal(aad, original)
-		decrypted, err := ctxR.Open(aad, encrypted)
-		assertNotError(t, suite, "Error in Open", err)
-		assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+	if rtt.aeadID == AEAD_EXPORT_ONLY {
+		_, err = ctxI.Seal(aad, original)
+		assert(t, suite, "Seal succeeded with export-only AEAD", errors.Is(err, ErrExportOnly))
+		_, err = ctxR.Open(aad, original)
+		assert(t, suite, "Open succeeded with export-only AEAD", errors.Is(err, ErrExportOnly))
+	} else {
+		for range make([]struct{}, rtts) {
+			encrypted, err := ctxI.Seal(aad, original)
+			assertNotError(t, suite, "Error in Seal", err)
+			decrypted, err := ctxR.Open(aad, encrypted)
+			assertNotError(t, suite, "Error in Open", err)
+			assertBytesEqual(t, suite, "Incorrect decryption", decrypted, original)
+		}
 	}
 
 	// Verify exporter functionality
-	exportedI := ctxI.Export(exportContext, exportLength)
-	exportedR := ctxR.Export(exportContext, exportLength)
+	exportedI, err := ctxI.Export(exportContext, exportLength)
+	assertNotError(t, suite, "Error exporting secret", err)
+
+	exportedR, err := ctxR.Export(exportContext, exportLength)
+	assertNotError(t, suite, "Error exporting secret", err)
+
 	assertBytesEqual(t, suite, "Incorrect exported secret", exportedI, exportedR)
 }
 
 func TestModes(t *testing.T) {
-	for kemID, _ := range kems {
-		for kdfID, _ := range kdfs {
-			for aeadID, _ := range aeads {
-				for mode, setup := range setupModes {
-					label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%02x", kemID, kdfID, aeadID, mode)
-					rtt := roundTripTest{kemID, kdfID, aeadID, setup}
-					t.Run(label, rtt.Test)
+	for _, version := range []Version{VersionRFC9180, VersionDraft} {
+		for kemID, _ := range kems {
+			for kdfID, _ := range kdfs {
+				for aeadID, _ := range aeads {
+					for mode, setup := range setupModes {
+						label := fmt.Sprintf("version=%02x/kem=%04x/kdf=%04x/aead=%04x/mode=%02x", version, kemID, kdfID, aeadID, mode)
+						rtt := roundTripTest{version, kemID, kdfID, aeadID, setup}
+						t.Run(label, rtt.Test)
+					}
 				}
 			}
 		}
 	}
 }
 
+func TestSingleShot(t *testing.T) {
+	for kemID, _ := range kems {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		skS, pkS, _ := mustGenerateKeyPair(t, suite)
+
+		enc, ct, err := SealBase(suite, rand.Reader, pkR, info, aad, original)
+		assertNotError(t, suite, "Error in SealBase", err)
+		pt, err := OpenBase(suite, skR, enc, info, aad, ct)
+		assertNotError(t, suite, "Error in OpenBase", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+		enc, ct, err = SealPSK(suite, rand.Reader, pkR, fixedPSK, fixedPSKID, info, aad, original)
+		assertNotError(t, suite, "Error in SealPSK", err)
+		pt, err = OpenPSK(suite, skR, enc, fixedPSK, fixedPSKID, info, aad, ct)
+		assertNotError(t, suite, "Error in OpenPSK", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+		if _, ok := suite.KEM.(AuthKEMScheme); !ok {
+			continue
+		}
+
+		enc, ct, err = SealAuth(suite, rand.Reader, pkR, skS, info, aad, original)
+		assertNotError(t, suite, "Error in SealAuth", err)
+		pt, err = OpenAuth(suite, skR, pkS, enc, info, aad, ct)
+		assertNotError(t, suite, "Error in OpenAuth", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+		enc, ct, err = SealAuthPSK(suite, rand.Reader, pkR, skS, fixedPSK, fixedPSKID, info, aad, original)
+		assertNotError(t, suite, "Error in SealAuthPSK", err)
+		pt, err = OpenAuthPSK(suite, skR, pkS, enc, fixedPSK, fixedPSKID, info, aad, ct)
+		assertNotError(t, suite, "Error in OpenAuthPSK", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+		// A ciphertext must not open under different associated data
+		_, err = OpenAuthPSK(suite, skR, pkS, enc, fixedPSK, fixedPSKID, info, info, ct)
+		assert(t, suite, "Open succeeded with incorrect AAD", err != nil)
+	}
+}
+
+func TestSingleShotExport(t *testing.T) {
+	for kemID, _ := range kems {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		skS, pkS, _ := mustGenerateKeyPair(t, suite)
+
+		enc, exportedS, err := SendExportBase(suite, rand.Reader, pkR, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in SendExportBase", err)
+		exportedR, err := ReceiveExportBase(suite, skR, enc, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in ReceiveExportBase", err)
+		assertBytesEqual(t, suite, "Incorrect exported secret", exportedS, exportedR)
+
+		enc, exportedS, err = SendExportPSK(suite, rand.Reader, pkR, fixedPSK, fixedPSKID, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in SendExportPSK", err)
+		exportedR, err = ReceiveExportPSK(suite, skR, enc, fixedPSK, fixedPSKID, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in ReceiveExportPSK", err)
+		assertBytesEqual(t, suite, "Incorrect exported secret", exportedS, exportedR)
+
+		if _, ok := suite.KEM.(AuthKEMScheme); !ok {
+			continue
+		}
+
+		enc, exportedS, err = SendExportAuth(suite, rand.Reader, pkR, skS, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in SendExportAuth", err)
+		exportedR, err = ReceiveExportAuth(suite, skR, pkS, enc, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in ReceiveExportAuth", err)
+		assertBytesEqual(t, suite, "Incorrect exported secret", exportedS, exportedR)
+
+		enc, exportedS, err = SendExportAuthPSK(suite, rand.Reader, pkR, skS, fixedPSK, fixedPSKID, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in SendExportAuthPSK", err)
+		exportedR, err = ReceiveExportAuthPSK(suite, skR, pkS, enc, fixedPSK, fixedPSKID, info, exportContext, exportLength)
+		assertNotError(t, suite, "Error in ReceiveExportAuthPSK", err)
+		assertBytesEqual(t, suite, "Incorrect exported secret", exportedS, exportedR)
+	}
+}
+
+func TestWithdrawnKEMs(t *testing.T) {
+	for kemID := range withdrawnKEMs {
+		if _, ok := kems[kemID]; ok {
+			// Compiled in with an opt-in build tag
+			continue
+		}
+
+		_, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if !errors.Is(err, ErrKEMWithdrawn) {
+			t.Fatalf("[%04x] Withdrawn KEM not reported as withdrawn: %v", kemID, err)
+		}
+	}
+}
+
+func TestReseal(t *testing.T) {
+	oldSuite, err := AssembleCipherSuite(DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	newSuite, err := AssembleCipherSuite(KEM_XWING, KDF_HKDF_SHA256, AEAD_CHACHA20POLY1305)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	verifyReseal(t, oldSuite, newSuite)
+}
+
+func verifyReseal(t *testing.T, oldSuite, newSuite CipherSuite) {
+	oldSkR, oldPkR, _ := mustGenerateKeyPair(t, oldSuite)
+	newSkR, newPkR, _ := mustGenerateKeyPair(t, newSuite)
+
+	enc, ct, err := SealBase(oldSuite, rand.Reader, oldPkR, info, aad, original)
+	assertNotError(t, oldSuite, "Error in SealBase", err)
+	enc, ct, err = ResealBase(oldSuite, oldSkR, enc, info, aad, ct, newSuite, rand.Reader, newPkR)
+	assertNotError(t, oldSuite, "Error in ResealBase", err)
+	pt, err := OpenBase(newSuite, newSkR, enc, info, aad, ct)
+	assertNotError(t, newSuite, "Error in OpenBase", err)
+	assertBytesEqual(t, newSuite, "Incorrect decryption", pt, original)
+
+	enc, ct, err = SealPSK(oldSuite, rand.Reader, oldPkR, fixedPSK, fixedPSKID, info, aad, original)
+	assertNotError(t, oldSuite, "Error in SealPSK", err)
+	enc, ct, err = ResealPSK(oldSuite, oldSkR, enc, fixedPSK, fixedPSKID, info, aad, ct, newSuite, rand.Reader, newPkR)
+	assertNotError(t, oldSuite, "Error in ResealPSK", err)
+	pt, err = OpenPSK(newSuite, newSkR, enc, fixedPSK, fixedPSKID, info, aad, ct)
+	assertNotError(t, newSuite, "Error in OpenPSK", err)
+	assertBytesEqual(t, newSuite, "Incorrect decryption", pt, original)
+
+	// Data that does not open under the old suite must not be resealed
+	_, _, err = ResealBase(oldSuite, oldSkR, enc, info, aad, ct, newSuite, rand.Reader, newPkR)
+	assert(t, oldSuite, "Reseal succeeded with invalid ciphertext", err != nil)
+}
+
+// TestConcurrentSuites shares one CipherSuite per KEM between goroutines that
+// mix deterministic and randomized encapsulation. Run it with -race.
+func TestConcurrentSuites(t *testing.T) {
+	const workers = 8
+
+	for _, kemID := range []KEMID{DHKEM_X25519, DHKEM_P256, KEM_MLKEM768, KEM_XWING, KEM_MLKEM768_P256} {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		skS, pkS, _ := mustGenerateKeyPair(t, suite)
+		_, isAuth := suite.KEM.(DeterministicAuthKEMScheme)
+
+		// Compute the expected encapsulations up front, one per worker
+		ikmEs := make([][]byte, workers)
+		encs := make([][]byte, workers)
+		for i := range ikmEs {
+			_, _, ikmEs[i] = mustGenerateEphemeral(t, suite)
+
+			encs[i], _, err = SetupBaseSDeterministic(suite, ikmEs[i], pkR, info)
+			assertNotError(t, suite, "Error in SetupBaseSDeterministic", err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				for j := 0; j < 4; j++ {
+					enc, ctxI, err := SetupBaseSDeterministic(suite, ikmEs[i], pkR, info)
+					if err != nil || !bytes.Equal(enc, encs[i]) {
+						t.Errorf("[%04x] Deterministic encapsulation changed under concurrency: %v", kemID, err)
+						return
+					}
+
+					ctxR, err := SetupBaseR(suite, skR, enc, info)
+					if err != nil {
+						t.Errorf("[%04x] Error in SetupBaseR: %v", kemID, err)
+						return
+					}
+
+					ct, _ := ctxI.Seal(aad, original)
+					if pt, err := ctxR.Open(aad, ct); err != nil || !bytes.Equal(pt, original) {
+						t.Errorf("[%04x] Incorrect decryption: %v", kemID, err)
+						return
+					}
+
+					// Randomized encapsulations must never reuse a fixed ephemeral key
+					enc, ct, err = SealBase(suite, rand.Reader, pkR, info, aad, original)
+					if err != nil || bytes.Equal(enc, encs[i]) {
+						t.Errorf("[%04x] Randomized encapsulation affected by a deterministic one: %v", kemID, err)
+						return
+					}
+
+					if _, err := OpenBase(suite, skR, enc, info, aad, ct); err != nil {
+						t.Errorf("[%04x] Error in OpenBase: %v", kemID, err)
+						return
+					}
+
+					if !isAuth {
+						continue
+					}
+
+					enc, _, err = SetupAuthSDeterministic(suite, ikmEs[i], pkR, skS, info)
+					if err != nil {
+						t.Errorf("[%04x] Error in SetupAuthSDeterministic: %v", kemID, err)
+						return
+					}
+
+					if _, err := SetupAuthR(suite, skR, pkS, enc, info); err != nil {
+						t.Errorf("[%04x] Error in SetupAuthR: %v", kemID, err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	}
+}
+
+func TestDeterministicEncapSupport(t *testing.T) {
+	for kemID, kem := range kems {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		if _, ok := kem.(DeterministicKEMScheme); !ok {
+			_, _, err := SetupBaseSDeterministic(suite, randomBytes(32), nil, info)
+			assert(t, suite, "Deterministic setup succeeded without KEM support", err != nil)
+			continue
+		}
+
+		_, pkR, _ := mustGenerateKeyPair(t, suite)
+		_, _, ikmE := mustGenerateEphemeral(t, suite)
+
+		encA, ctxA, err := SetupBaseSDeterministic(suite, ikmE, pkR, info)
+		assertNotError(t, suite, "Error in SetupBaseSDeterministic", err)
+		encB, ctxB, err := SetupBaseSDeterministic(suite, ikmE, pkR, info)
+		assertNotError(t, suite, "Error in SetupBaseSDeterministic", err)
+		assertBytesEqual(t, suite, "Non-deterministic encapsulation", encA, encB)
+		assertBytesEqual(t, suite, "Non-deterministic key schedule", ctxA.key, ctxB.key)
+
+		if !hasEphemeralKeyPair(suite.KEM) {
+			_, _, err = SetupBaseSDeterministic(suite, ikmE[1:], pkR, info)
+			assert(t, suite, "Deterministic setup succeeded with a short seed", err != nil)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	suite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	Nh := suite.KDF.OutputSize()
+
+	// Malformed keys and encapsulations
+	_, err = suite.KEM.Unmarshal(randomBytes(suite.KEM.PublicKeySize() - 1))
+	assert(t, suite, "Short public key not reported as invalid", errors.Is(err, ErrInvalidKEMPublicKey))
+
+	_, err = suite.KEM.UnmarshalPrivate(randomBytes(suite.KEM.PrivateKeySize() + 1))
+	assert(t, suite, "Long private key not reported as invalid", errors.Is(err, ErrInvalidKEMPrivateKey))
+
+	_, err = OpenBase(suite, skR, []byte{0x00}, info, aad, original)
+	assert(t, suite, "Short encapsulation not reported as invalid", errors.Is(err, ErrInvalidKEMPublicKey))
+
+	for _, kemID := range []KEMID{KEM_MLKEM768, KEM_MLKEM1024, KEM_XWING, KEM_MLKEM768_P256, KEM_MLKEM1024_P384, KEM_FRODO640SHAKE, KEM_RSA2048} {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		enc, _, err := SetupBaseS(suite, rand.Reader, pkR, info)
+		assertNotError(t, suite, "Error in SetupBaseS", err)
+
+		_, err = SetupBaseR(suite, skR, enc[1:], info)
+		assert(t, suite, "Short encapsulation not reported as invalid", errors.Is(err, ErrInvalidKEMPublicKey))
+	}
+
+	// Inconsistent PSK inputs
+	_, _, err = SealPSK(suite, rand.Reader, pkR, fixedPSK, nil, info, aad, original)
+	assert(t, suite, "PSK without ID not reported", errors.Is(err, ErrInvalidPSKConfig))
+
+	_, _, err = SealPSK(suite, rand.Reader, pkR, defaultPSK(suite), defaultPSKID(suite), info, aad, original)
+	assert(t, suite, "PSK mode without PSK not reported", errors.Is(err, ErrInvalidPSKConfig))
+
+	// Authentication failures
+	enc, ct, err := SealBase(suite, rand.Reader, pkR, info, aad, original)
+	assertNotError(t, suite, "Error in SealBase", err)
+
+	ct[0] ^= 0x01
+	_, err = OpenBase(suite, skR, enc, info, aad, ct)
+	assert(t, suite, "Modified ciphertext not reported", errors.Is(err, ErrOpen))
+
+	// Exporter lengths
+	enc, ctxI, err := SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	_, err = ctxI.Export(nil, 255*Nh)
+	assertNotError(t, suite, "Error exporting the maximum length", err)
+
+	_, err = ctxI.Export(nil, 255*Nh+1)
+	assert(t, suite, "Long export not reported", errors.Is(err, ErrExportLengthTooLarge))
+
+	_, err = ctxI.Export(nil, -1)
+	assert(t, suite, "Negative export length accepted", err != nil)
+
+	_, err = ReceiveExportBase(suite, skR, enc, info, nil, 1<<16)
+	assert(t, suite, "Long single-shot export not reported", errors.Is(err, ErrExportLengthTooLarge))
+
+	// Sequence number exhaustion
+	ctxI.seq = math.MaxUint64
+	_, err = ctxI.Seal(aad, original)
+	assert(t, suite, "Exhausted sequence number not reported", errors.Is(err, ErrMessageLimitReached))
+
+	// Auth mode without an AuthKEMScheme
+	suite, err = AssembleCipherSuite(KEM_MLKEM768, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	skR, pkR, _ = mustGenerateKeyPair(t, suite)
+	enc, _, err = SetupBaseS(suite, rand.Reader, pkR, info)
+	assertNotError(t, suite, "Error in SetupBaseS", err)
+
+	_, _, err = SetupAuthS(suite, rand.Reader, pkR, skR, info)
+	assert(t, suite, "SetupAuthS without Auth support", errors.Is(err, ErrAuthNotSupported))
+
+	_, err = SetupAuthR(suite, skR, pkR, enc, info)
+	assert(t, suite, "SetupAuthR without Auth support", errors.Is(err, ErrAuthNotSupported))
+
+	_, _, err = SealAuthPSK(suite, rand.Reader, pkR, skR, fixedPSK, fixedPSKID, info, aad, original)
+	assert(t, suite, "SealAuthPSK without Auth support", errors.Is(err, ErrAuthNotSupported))
+
+	_, err = OpenAuthPSK(suite, skR, pkR, enc, fixedPSK, fixedPSKID, info, aad, original)
+	assert(t, suite, "OpenAuthPSK without Auth support", errors.Is(err, ErrAuthNotSupported))
+
+	_, _, err = SendExportAuth(suite, rand.Reader, pkR, skR, info, nil, Nh)
+	assert(t, suite, "SendExportAuth without Auth support", errors.Is(err, ErrAuthNotSupported))
+
+	_, _, err = SetupAuthSDeterministic(suite, randomBytes(32), pkR, skR, info)
+	assert(t, suite, "SetupAuthSDeterministic without Auth support", errors.Is(err, ErrAuthNotSupported))
+}
+
+func TestSupportsMode(t *testing.T) {
+	for kemID, kem := range kems {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		_, isAuth := kem.(AuthKEMScheme)
+		assert(t, suite, "Base mode not supported", suite.SupportsMode(ModeBase))
+		assert(t, suite, "PSK mode not supported", suite.SupportsMode(ModePSK))
+		assert(t, suite, "Incorrect Auth mode support", suite.SupportsMode(ModeAuth) == isAuth)
+		assert(t, suite, "Incorrect AuthPSK mode support", suite.SupportsMode(ModeAuthPSK) == isAuth)
+		assert(t, suite, "Unknown mode supported", !suite.SupportsMode(HPKEMode(0x04)))
+
+		if !isAuth {
+			skR, pkR, _ := mustGenerateKeyPair(t, suite)
+			_, _, err = SetupAuthPSKS(suite, rand.Reader, pkR, skR, fixedPSK, fixedPSKID, info)
+			assert(t, suite, "Unsupported mode not reported", errors.Is(err, ErrAuthNotSupported))
+		}
+	}
+}
+
+func TestPSKInputs(t *testing.T) {
+	suite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	shortPSK := fixedPSK[:minPSKSize-1]
+	zeroPSK := make([]byte, suite.KDF.OutputSize())
+
+	cases := []struct {
+		mode     HPKEMode
+		psk      []byte
+		pskID    []byte
+		expected error
+	}{
+		{ModeBase, nil, nil, nil},
+		{ModeBase, fixedPSK, fixedPSKID, ErrUnexpectedPSK},
+		{ModeBase, fixedPSK, nil, ErrPSKWithoutID},
+		{ModeBase, nil, fixedPSKID, ErrPSKIDWithoutPSK},
+		{ModeAuth, nil, nil, nil},
+		{ModeAuth, fixedPSK, fixedPSKID, ErrUnexpectedPSK},
+		{ModePSK, fixedPSK, fixedPSKID, nil},
+		{ModePSK, zeroPSK, fixedPSKID, nil},
+		{ModePSK, nil, nil, ErrMissingPSK},
+		{ModePSK, fixedPSK, nil, ErrPSKWithoutID},
+		{ModePSK, fixedPSK, []byte{}, ErrPSKWithoutID},
+		{ModePSK, nil, fixedPSKID, ErrPSKIDWithoutPSK},
+		{ModePSK, shortPSK, fixedPSKID, ErrPSKTooShort},
+		{ModePSK, []byte{}, fixedPSKID, ErrPSKTooShort},
+		{ModeAuthPSK, fixedPSK, fixedPSKID, nil},
+		{ModeAuthPSK, nil, nil, ErrMissingPSK},
+		{ModeAuthPSK, fixedPSK, nil, ErrPSKWithoutID},
+		{ModeAuthPSK, nil, fixedPSKID, ErrPSKIDWithoutPSK},
+		{ModeAuthPSK, shortPSK, fixedPSKID, ErrPSKTooShort},
+	}
+
+	for i, c := range cases {
+		err := verifyPSKInputs(suite, c.mode, c.psk, c.pskID)
+		if c.expected == nil && err != nil {
+			t.Fatalf("[%d] Valid PSK inputs rejected: %v", i, err)
+		}
+
+		if c.expected != nil && (!errors.Is(err, c.expected) || !errors.Is(err, ErrInvalidPSKConfig)) {
+			t.Fatalf("[%d] Incorrect error for invalid PSK inputs: %v", i, err)
+		}
+	}
+
+	// An all-zero PSK is a PSK, not the absence of one
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	enc, ct, err := SealPSK(suite, rand.Reader, pkR, zeroPSK, fixedPSKID, info, aad, original)
+	assertNotError(t, suite, "Error in SealPSK", err)
+
+	_, err = OpenBase(suite, skR, enc, info, aad, ct)
+	assert(t, suite, "PSK mode ciphertext opened in Base mode", errors.Is(err, ErrOpen))
+
+	_, _, err = SealPSK(suite, rand.Reader, pkR, shortPSK, fixedPSKID, info, aad, original)
+	assert(t, suite, "Short PSK accepted", errors.Is(err, ErrPSKTooShort))
+
+	// VersionDraft predates the minimum PSK size
+	suite.Version = VersionDraft
+	_, _, err = SealPSK(suite, rand.Reader, pkR, shortPSK, fixedPSKID, info, aad, original)
+	assertNotError(t, suite, "Short PSK rejected by VersionDraft", err)
+}
+
+func TestSetupOptions(t *testing.T) {
+	suite, err := AssembleCipherSuite(DHKEM_P256, KDF_HKDF_SHA256, AEAD_AESGCM128)
+	if err != nil {
+		t.Fatalf("Error looking up ciphersuite: %v", err)
+	}
+
+	skR, pkR, _ := mustGenerateKeyPair(t, suite)
+	skS, pkS, _ := mustGenerateKeyPair(t, suite)
+
+	modes := []struct {
+		mode       HPKEMode
+		senderOpts []SetupOption
+		recipOpts  []SetupOption
+	}{
+		{ModeBase, nil, nil},
+		{ModePSK, []SetupOption{WithPSK(fixedPSK, fixedPSKID)}, []SetupOption{WithPSK(fixedPSK, fixedPSKID)}},
+		{ModeAuth, []SetupOption{WithSenderKey(skS)}, []SetupOption{WithSenderPublicKey(pkS)}},
+		{ModeAuthPSK, []SetupOption{WithPSK(fixedPSK, fixedPSKID), WithSenderKey(skS)}, []SetupOption{WithSenderPublicKey(pkS), WithPSK(fixedPSK, fixedPSKID)}},
+	}
+
+	for _, m := range modes {
+		senderOpts := append([]SetupOption{WithInfo(info)}, m.senderOpts...)
+		recipOpts := append([]SetupOption{WithInfo(info)}, m.recipOpts...)
+
+		enc, ctxI, err := SetupSender(suite, pkR, senderOpts...)
+		assertNotError(t, suite, "Error in SetupSender", err)
+		ctxR, err := SetupRecipient(suite, skR, enc, recipOpts...)
+		assertNotError(t, suite, "Error in SetupRecipient", err)
+		assert(t, suite, "Incorrect mode", ctxI.contextParams.keyScheduleContext[0] == byte(m.mode))
+
+		// The positional functions derive the same context
+		setup := setupModes[m.mode]
+		ctxX, err := setup.R(suite, skR, enc, info, pkS, fixedPSK, fixedPSKID)
+		assertNotError(t, suite, "Error in positional setup", err)
+		assertBytesEqual(t, suite, "Incorrect key", ctxX.key, ctxR.key)
+
+		ct, err := ctxI.Seal(aad, original)
+		assertNotError(t, suite, "Error in Seal", err)
+		pt, err := ctxR.Open(aad, ct)
+		assertNotError(t, suite, "Error in Open", err)
+		assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+	}
+
+	_, _, err = SetupSender(suite, pkR, WithSenderPublicKey(pkS))
+	assert(t, suite, "Recipient option accepted by SetupSender", err != nil)
+
+	enc, _, err := SetupSender(suite, pkR, WithRand(rand.Reader))
+	assertNotError(t, suite, "Error in SetupSender", err)
+
+	_, err = SetupRecipient(suite, skR, enc, WithSenderKey(skS))
+	assert(t, suite, "Sender option accepted by SetupRecipient", err != nil)
+
+	_, _, err = SetupSender(suite, pkR, WithPSK(nil, nil))
+	assert(t, suite, "PSK mode without a PSK", errors.Is(err, ErrInvalidPSKConfig))
+}
+
+func TestSignedSetup(t *testing.T) {
+	_, ed25519Signer, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating Ed25519 key: %v", err)
+	}
+
+	ecdsaSigner, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ECDSA key: %v", err)
+	}
+
+	_, mldsaSigner, err := mldsa65.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating ML-DSA key: %v", err)
+	}
+
+	signers := []crypto.Signer{ed25519Signer, ecdsaSigner, mldsaSigner}
+	for _, kemID := range []KEMID{KEM_MLKEM768, KEM_XWING, DHKEM_X25519} {
+		suite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		skR, pkR, _ := mustGenerateKeyPair(t, suite)
+		otherSkR, _, _ := mustGenerateKeyPair(t, suite)
+		for i, signer := range signers {
+			pkS := signer.Public()
+			otherPkS := signers[(i+1)%len(signers)].Public()
+
+			enc, sig, ctxI, err := SetupSignedS(suite, rand.Reader, pkR, signer, info)
+			assertNotError(t, suite, "Error in SetupSignedS", err)
+
+			ctxR, err := SetupSignedR(suite, skR, pkS, enc, sig, info)
+			assertNotError(t, suite, "Error in SetupSignedR", err)
+
+			ct, err := ctxI.Seal(aad, original)
+			assertNotError(t, suite, "Error in Seal", err)
+			pt, err := ctxR.Open(aad, ct)
+			assertNotError(t, suite, "Error in Open", err)
+			assertBytesEqual(t, suite, "Incorrect decryption", pt, original)
+
+			_, err = SetupSignedR(suite, skR, otherPkS, enc, sig, info)
+			assert(t, suite, "Signature verified with another key", errors.Is(err, ErrBadSignature))
+
+			_, err = SetupSignedR(suite, skR, pkS, enc, sig, []byte("other info"))
+			assert(t, suite, "Signature verified with other info", errors.Is(err, ErrBadSignature))
+
+			_, err = SetupSignedR(suite, otherSkR, pkS, enc, sig, info)
+			assert(t, suite, "Signature verified for another recipient", errors.Is(err, ErrBadSignature))
+
+			_, err = SetupSignedPSKR(suite, skR, pkS, enc, sig, fixedPSK, fixedPSKID, info)
+			assert(t, suite, "Signature verified in another mode", errors.Is(err, ErrBadSignature))
+
+			badSig := append([]byte{}, sig...)
+			badSig[len(badSig)/2] ^= 0x01
+			_, err = SetupSignedR(suite, skR, pkS, enc, badSig, info)
+			assert(t, suite, "Modified signature verified", errors.Is(err, ErrBadSignature))
+
+			// The signature is bound into the key schedule
+			ctxBase, err := SetupBaseR(suite, skR, enc, info)
+			assertNotError(t, suite, "Error in SetupBaseR", err)
+			_, err = ctxBase.Open(aad, ct)
+			assert(t, suite, "Signed context matches the Base mode context", errors.Is(err, ErrOpen))
+
+			enc, sig, ctxI, err = SetupSignedPSKS(suite, rand.Reader, pkR, signer, fixedPSK, fixedPSKID, info)
+			assertNotError(t, suite, "Error in SetupSignedPSKS", err)
+
+			ctxR, err = SetupSignedPSKR(suite, skR, pkS, enc, sig, fixedPSK, fixedPSKID, info)
+			assertNotError(t, suite, "Error in SetupSignedPSKR", err)
+
+			exportI, err := ctxI.Export(nil, 32)
+			assertNotError(t, suite, "Error exporting secret", err)
+			exportR, err := ctxR.Export(nil, 32)
+			assertNotError(t, suite, "Error exporting secret", err)
+			assertBytesEqual(t, suite, "Incorrect exported secret", exportI, exportR)
+		}
+	}
+}
+
 ///////
 // Generation and processing of test vectors
 
 func verifyEncryptions(tv testVector, enc *EncryptContext, dec *DecryptContext) {
 	for _, data := range tv.encryptions {
-		encrypted := enc.Seal(data.aad, data.plaintext)
+		encrypted, err := enc.Seal(data.aad, data.plaintext)
+		assertNotError(tv.t, tv.suite, "Error in Seal", err)
 		decrypted, err := dec.Open(data.aad, encrypted)
 
 		assertNotError(tv.t, tv.suite, "Error in Open", err)
@@ -1238,20 +1972,71 @@ func verifyEncryptions(tv testVector, enc *EncryptContext, dec *DecryptContext)
 	}
 }
 
+func verifyExports(tv testVector, enc *EncryptContext, dec *DecryptContext) {
+	for _, data := range tv.exports {
+		exportI, err := enc.Export(data.exportContext, data.exportLength)
+		assertNotError(tv.t, tv.suite, "Error exporting secret", err)
+
+		exportR, err := dec.Export(data.exportContext, data.exportLength)
+		assertNotError(tv.t, tv.suite, "Error exporting secret", err)
+
+		assertBytesEqual(tv.t, tv.suite, "Incorrect export", exportI, data.exportValue)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect export", exportR, data.exportValue)
+
+		setup := setupModes[tv.mode]
+		exportX, err := setup.RX(tv.suite, tv.skR, tv.enc, tv.info, tv.pkS, tv.psk, tv.pskID, data.exportContext, data.exportLength)
+		assertNotError(tv.t, tv.suite, "Error in ReceiveExport", err)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect single-shot export", exportX, data.exportValue)
+	}
+}
+
 func verifyParameters(tv testVector, ctx cipherContext) {
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'zz'", tv.zz, ctx.setupParams.zz)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'shared_secret'", tv.sharedSecret, ctx.setupParams.sharedSecret)
 	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'enc'", tv.enc, ctx.setupParams.enc)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key_schedule_context'", tv.keyScheduleContext, ctx.contextParams.keyScheduleContext)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'secret'", tv.secret, ctx.contextParams.secret)
+
+	// The vectors of draft-ietf-hpke-pq carry no intermediate key schedule values.
+	if len(tv.keyScheduleContext) > 0 || len(tv.secret) > 0 {
+		assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key_schedule_context'", tv.keyScheduleContext, ctx.contextParams.keyScheduleContext)
+		assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'secret'", tv.secret, ctx.contextParams.secret)
+	}
 	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'key'", tv.key, ctx.key)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'nonce'", tv.nonce, ctx.nonce)
-	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'exporterSecret'", tv.exporterSecret, ctx.exporterSecret)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'base_nonce'", tv.baseNonce, ctx.nonce)
+	assertBytesEqual(tv.t, tv.suite, "Incorrect parameter 'exporter_secret'", tv.exporterSecret, ctx.exporterSecret)
+}
+
+func verifyDerivedKeyPair(tv testVector, ikm []byte, sk KEMPrivateKey, pk KEMPublicKey) {
+	// Vectors for protocol versions without DeriveKeyPair carry no IKM.
+	if len(ikm) == 0 {
+		return
+	}
+
+	skD, pkD, err := tv.suite.KEM.DeriveKeyPair(ikm)
+	assertNotError(tv.t, tv.suite, "Error in DeriveKeyPair", err)
+	assertBytesEqual(tv.t, tv.suite, "Derived private key mismatch", mustMarshalPrivate(tv.t, tv.suite.KEM, skD), mustMarshalPrivate(tv.t, tv.suite.KEM, sk))
+	assertBytesEqual(tv.t, tv.suite, "Derived public key mismatch", mustMarshal(tv.t, tv.suite.KEM, pkD), mustMarshal(tv.t, tv.suite.KEM, pk))
 }
 
 func verifyTestVector(tv testVector) {
 	setup := setupModes[tv.mode]
 
-	enc, ctxI, err := setup.I(tv.suite, tv.pkR, tv.info, tv.skS, tv.psk, tv.pskID)
+	verifyDerivedKeyPair(tv, tv.ikmR, tv.skR, tv.pkR)
+	if tv.skE != nil {
+		verifyDerivedKeyPair(tv, tv.ikmE, tv.skE, tv.pkE)
+	}
+	if tv.mode == ModeAuth || tv.mode == ModeAuthPSK {
+		verifyDerivedKeyPair(tv, tv.ikmS, tv.skS, tv.pkS)
+	}
+
+	var enc []byte
+	var ctxI *EncryptContext
+	var err error
+	if len(tv.ikmE) > 0 {
+		enc, ctxI, err = setup.ID(tv.suite, tv.ikmE, tv.pkR, tv.info, tv.skS, tv.psk, tv.pskID)
+	} else {
+		suite := tv.suite
+		suite.KEM = fixedEphemeralKEM{tv.suite.KEM.(*dhkemScheme), tv.skE}
+		enc, ctxI, err = setup.I(suite, tv.pkR, tv.info, tv.skS, tv.psk, tv.pskID)
+	}
 	assertNotError(tv.t, tv.suite, "Error in SetupI", err)
 	assertBytesEqual(tv.t, tv.suite, "Encapsulated key mismatch", enc, tv.enc)
 
@@ -1262,10 +2047,16 @@ func verifyTestVector(tv testVector) {
 	verifyParameters(tv, ctxR.cipherContext)
 
 	verifyEncryptions(tv, ctxI, ctxR)
+	verifyExports(tv, ctxI, ctxR)
 }
 
 func vectorTest(vector testVector) func(t *testing.T) {
 	return func(t *testing.T) {
+		if vector.unsupported {
+			t.Skip("Unsupported ciphersuite")
+		}
+
+		vector.t = t
 		verifyTestVector(vector)
 	}
 }
@@ -1282,17 +2073,23 @@ func verifyTestVectors(t *testing.T, vectorString []byte, subtest bool) {
 		if !subtest {
 			test(t)
 		} else {
-			label := fmt.Sprintf("kem=%04x/kdf=%04x/aead=%04x/mode=%02x", tv.kemID, tv.kdfID, tv.aeadID, tv.mode)
+			label := fmt.Sprintf("version=%02x/kem=%04x/kdf=%04x/aead=%04x/mode=%02x", tv.version, tv.kemID, tv.kdfID, tv.aeadID, tv.mode)
 			t.Run(label, test)
 		}
 	}
 }
 
 func generateEncryptions(t *testing.T, suite CipherSuite, ctxI *EncryptContext, ctxR *DecryptContext) ([]encryptionTestVector, error) {
+	// Export-only suites cannot encrypt
+	if suite.AEAD.ID() == AEAD_EXPORT_ONLY {
+		return []encryptionTestVector{}, nil
+	}
+
 	vectors := make([]encryptionTestVector, testVectorEncryptionCount)
 	for i := 0; i < len(vectors); i++ {
 		aad := []byte(fmt.Sprintf("Count-%d", i))
-		encrypted := ctxI.Seal(aad, original)
+		encrypted, err := ctxI.Seal(aad, original)
+		assertNotError(t, suite, "Encryption failure", err)
 		decrypted, err := ctxR.Open(aad, encrypted)
 		assertNotError(t, suite, "Decryption failure", err)
 		assertBytesEqual(t, suite, "Incorrect decryption", original, decrypted)
@@ -1312,8 +2109,11 @@ func generateExports(t *testing.T, suite CipherSuite, ctxI *EncryptContext, ctxR
 	vectors := make([]exporterTestVector, testVectorExportCount)
 	for i := 0; i < len(vectors); i++ {
 		context := []byte(fmt.Sprintf("Context-%d", i))
-		exportI := ctxI.Export(context, testVectorExportLength)
-		exportR := ctxR.Export(context, testVectorExportLength)
+		exportI, err := ctxI.Export(context, testVectorExportLength)
+		assertNotError(t, suite, "Error exporting secret", err)
+
+		exportR, err := ctxR.Export(context, testVectorExportLength)
+		assertNotError(t, suite, "Error exporting secret", err)
 		assertBytesEqual(t, suite, "Incorrect export", exportI, exportR)
 		vectors[i] = exporterTestVector{
 			exportContext: context,
@@ -1325,33 +2125,32 @@ func generateExports(t *testing.T, suite CipherSuite, ctxI *EncryptContext, ctxR
 	return vectors, nil
 }
 
-func generateTestVector(t *testing.T, setup setupMode, kemID KEMID, kdfID KDFID, aeadID AEADID) testVector {
-	suite, err := AssembleCipherSuite(kemID, kdfID, aeadID)
+func generateTestVector(t *testing.T, setup setupMode, version Version, kemID KEMID, kdfID KDFID, aeadID AEADID) testVector {
+	suite, err := AssembleVersionedCipherSuite(version, kemID, kdfID, aeadID)
 	if err != nil {
 		t.Fatalf("[%x, %x, %x] Error looking up ciphersuite: %s", kemID, kdfID, aeadID, err)
 	}
 
-	skR, pkR := mustGenerateKeyPair(t, suite)
-	skE, pkE := mustGenerateKeyPair(t, suite)
+	skR, pkR, ikmR := mustGenerateKeyPair(t, suite)
+	skE, pkE, ikmE := mustGenerateEphemeral(t, suite)
 
 	// The sender key share is only required for Auth mode variants.
 	var pkS KEMPublicKey
 	var skS KEMPrivateKey
-	if setup.Mode == modeAuth || setup.Mode == modeAuthPSK {
-		skS, pkS = mustGenerateKeyPair(t, suite)
+	var ikmS []byte
+	if setup.Mode == ModeAuth || setup.Mode == ModeAuthPSK {
+		skS, pkS, ikmS = mustGenerateKeyPair(t, suite)
 	}
 
 	// A PSK is only required for PSK mode variants.
 	var psk []byte
 	var pskID []byte
-	if setup.Mode == modePSK || setup.Mode == modeAuthPSK {
+	if setup.Mode == ModePSK || setup.Mode == ModeAuthPSK {
 		psk = fixedPSK
 		pskID = fixedPSKID
 	}
 
-	suite.KEM.setEphemeralKeyPair(skE)
-
-	enc, ctxI, err := setup.I(suite, pkR, info, skS, psk, pskID)
+	enc, ctxI, err := setup.ID(suite, ikmE, pkR, info, skS, psk, pskID)
 	assertNotError(t, suite, "Error in SetupPSKS", err)
 
 	ctxR, err := setup.R(suite, skR, enc, info, pkS, psk, pskID)
@@ -1366,11 +2165,15 @@ func generateTestVector(t *testing.T, setup setupMode, kemID KEMID, kdfID KDFID,
 	vector := testVector{
 		t:                  t,
 		suite:              suite,
+		version:            version,
 		mode:               setup.Mode,
 		kemID:              kemID,
 		kdfID:              kdfID,
 		aeadID:             aeadID,
 		info:               info,
+		ikmR:               ikmR,
+		ikmS:               ikmS,
+		ikmE:               ikmE,
 		skR:                skR,
 		pkR:                pkR,
 		skS:                skS,
@@ -1380,11 +2183,11 @@ func generateTestVector(t *testing.T, setup setupMode, kemID KEMID, kdfID KDFID,
 		skE:                skE,
 		pkE:                pkE,
 		enc:                ctxI.setupParams.enc,
-		zz:                 ctxI.setupParams.zz, //share secret
+		sharedSecret:       ctxI.setupParams.sharedSecret,
 		keyScheduleContext: ctxI.contextParams.keyScheduleContext,
 		secret:             ctxI.contextParams.secret,
 		key:                ctxI.key,
-		nonce:              ctxI.nonce,
+		baseNonce:          ctxI.nonce,
 		exporterSecret:     ctxI.exporterSecret,
 		encryptions:        encryptionVectors,
 		exports:            exportVectors,
@@ -1395,16 +2198,25 @@ func generateTestVector(t *testing.T, setup setupMode, kemID KEMID, kdfID KDFID,
 
 func TestVectorGenerate(t *testing.T) {
 	// We only generate test vectors for select ciphersuites
-	supportedKEMs := []KEMID{DHKEM_X25519, DHKEM_X448, DHKEM_P256, DHKEM_P521}
-	supportedKDFs := []KDFID{KDF_HKDF_SHA256, KDF_HKDF_SHA512, KDF_HKDF_SHA3_256}
-	supportedAEADs := []AEADID{AEAD_AESGCM128, AEAD_AESGCM256, AEAD_CHACHA20POLY1305}
+	supportedKEMs := []KEMID{DHKEM_X25519, DHKEM_X448, DHKEM_P256, DHKEM_P384, DHKEM_P521, KEM_MLKEM768, KEM_MLKEM1024, KEM_MLKEM768_P256, KEM_MLKEM1024_P384, KEM_XWING, DHKEM_SM2, DHKEM_SECP256K1, DHKEM_SECP256K1_COMPRESSED, KEM_FRODO640SHAKE}
+	supportedKDFs := []KDFID{KDF_HKDF_SHA256, KDF_HKDF_SHA384, KDF_HKDF_SHA512, KDF_HKDF_SHA3_256}
+	supportedAEADs := []AEADID{AEAD_AESGCM128, AEAD_AESGCM256, AEAD_CHACHA20POLY1305, AEAD_EXPORT_ONLY}
+
+	supportedVersions := []Version{VersionRFC9180, VersionDraft}
 
 	vectors := make([]testVector, 0)
-	for _, kemID := range supportedKEMs {
-		for _, kdfID := range supportedKDFs {
-			for _, aeadID := range supportedAEADs {
-				for _, setup := range setupModes {
-					vectors = append(vectors, generateTestVector(t, setup, kemID, kdfID, aeadID))
+	for _, version := range supportedVersions {
+		for _, kemID := range supportedKEMs {
+			for _, kdfID := range supportedKDFs {
+				for _, aeadID := range supportedAEADs {
+					for _, setup := range setupModes {
+						// Skip modes that the KEM does not support
+						if !setup.OK(CipherSuite{KEM: kems[kemID]}) {
+							continue
+						}
+
+						vectors = append(vectors, generateTestVector(t, setup, version, kemID, kdfID, aeadID))
+					}
 				}
 			}
 		}
@@ -1429,16 +2241,34 @@ func TestVectorGenerate(t *testing.T) {
 	}
 }
 
-func TestVectorVerify(t *testing.T) {
-	var inputFile string
-	if inputFile = os.Getenv(inputTestVectorEnvironmentKey); len(inputFile) == 0 {
-		t.Skip("Test vectors were not provided")
+// TestHPKEPQVectors checks ML-KEM, X-Wing and the hybrid KEMs against the test
+// vectors of draft-ietf-hpke-pq, as distributed with filippo.io/hpke. Vectors
+// for the one-stage KDFs of draft-ietf-hpke-hpke are skipped.
+func TestHPKEPQVectors(t *testing.T) {
+	vectors, err := os.ReadFile("testdata/hpke-pq-vectors.json")
+	if err != nil {
+		t.Fatalf("Failed reading test vectors: %v", err)
 	}
 
-	encoded, err := ioutil.ReadFile(inputFile)
+	verifyTestVectors(t, vectors, true)
+}
+
+func TestVectorVerify(t *testing.T) {
+	inputFiles, err := filepath.Glob(testVectorFilePattern)
 	if err != nil {
-		t.Fatalf("Failed reading test vectors: %v", err)
+		t.Fatalf("Failed listing test vectors: %v", err)
 	}
 
-	verifyTestVectors(t, encoded, true)
+	if inputFile := os.Getenv(inputTestVectorEnvironmentKey); len(inputFile) > 0 {
+		inputFiles = []string{inputFile}
+	}
+
+	for _, inputFile := range inputFiles {
+		encoded, err := ioutil.ReadFile(inputFile)
+		if err != nil {
+			t.Fatalf("Failed reading test vectors: %v", err)
+		}
+
+		verifyTestVectors(t, encoded, true)
+	}
 }
@@ -0,0 +1,399 @@
+package hpke
+
+import (
+	"crypto/rsa"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+
+	"filippo.io/bigmod"
+)
+
+//////////
+// RSA-KEM
+
+// rsaKEMExponent is the public exponent of derived key pairs.
+const rsaKEMExponent = 65537
+
+type rsaPublicKey struct {
+	kemID KEMID
+	pub   *rsa.PublicKey
+}
+
+func (pub rsaPublicKey) KEMID() KEMID {
+	return pub.kemID
+}
+
+// Bytes returns the SubjectPublicKeyInfo encoding of the key.
+func (pub rsaPublicKey) Bytes() []byte {
+	enc, err := x509.MarshalPKIXPublicKey(pub.pub)
+	if err != nil {
+		return nil
+	}
+	return enc
+}
+
+func (pub rsaPublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*rsaPublicKey)
+	return ok && pub.kemID == o.kemID && pub.pub.Equal(o.pub)
+}
+
+type rsaPrivateKey struct {
+	kemID KEMID
+	priv  *rsa.PrivateKey
+}
+
+func (priv rsaPrivateKey) KEMID() KEMID {
+	return priv.kemID
+}
+
+// Bytes returns the PKCS #1 encoding of the key.
+func (priv rsaPrivateKey) Bytes() []byte {
+	return x509.MarshalPKCS1PrivateKey(priv.priv)
+}
+
+func (priv rsaPrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*rsaPrivateKey)
+	return ok && priv.kemID == o.kemID && subtle.ConstantTimeCompare(priv.Bytes(), o.Bytes()) == 1
+}
+
+func (priv rsaPrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
+}
+
+func (priv rsaPrivateKey) PublicKey() KEMPublicKey {
+	return &rsaPublicKey{priv.kemID, &priv.priv.PublicKey}
+}
+
+// rsaKEMScheme is the RSA-KEM of ISO 18033-2 and RFC 9690, so that recipients
+// with existing RSA keys can use HPKE. Encap picks a random integer z in
+// [0, n-1] and encrypts it with the raw RSA function (RSASVE), and the shared
+// secret is KDF3(Z, Nsecret) as in RFC 9690, Section 2.2, with the hash of
+// the KDF and Nsecret its output size.
+//
+// Public keys are marshaled as SubjectPublicKeyInfo and private keys as
+// PKCS #1. RSA-KEM has no sender key pair, so the Auth modes are not
+// supported.
+type rsaKEMScheme struct {
+	kemID KEMID
+	bits  int
+	KDF   KDFScheme
+}
+
+func (s rsaKEMScheme) ID() KEMID {
+	return s.kemID
+}
+
+// GenerateKeyPair generates the key pair with rsa.GenerateKey.
+func (s rsaKEMScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	priv, err := rsa.GenerateKey(rand, s.bits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sk := &rsaPrivateKey{s.kemID, priv}
+	return sk, sk.PublicKey(), nil
+}
+
+// DeriveKeyPair is not part of RSA-KEM, which defines no key derivation, and
+// only exists so that test vectors can fix the recipient's key pair. It
+// searches for each prime upwards from a candidate expanded from the IKM, as
+// rejection sampling would be too slow, and uses the public exponent 65537.
+// Derived key pairs are only reproducible with this implementation and must
+// not be used outside of tests; use GenerateKeyPair or an existing key.
+func (s rsaKEMScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	suiteID := kemSuiteID(s.ID())
+	dkpPRK := s.KDF.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
+
+	e := big.NewInt(rsaKEMExponent)
+	one := big.NewInt(1)
+
+	var primes []*big.Int
+	for counter := 0; counter < 256 && len(primes) < 2; counter++ {
+		candidate, err := s.KDF.LabeledExpand(dkpPRK, suiteID, "candidate", []byte{byte(counter)}, s.bits/16)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		p, ok := nextRSAPrime(candidate, e)
+		if !ok {
+			continue
+		}
+
+		// As in FIPS 186-5, Appendix A.1.3, p and q must not be too close
+		if len(primes) == 1 {
+			diff := new(big.Int).Sub(primes[0], p)
+			if diff.Abs(diff).BitLen() <= s.bits/2-100 {
+				continue
+			}
+		}
+		primes = append(primes, p)
+	}
+	if len(primes) < 2 {
+		return nil, nil, fmt.Errorf("Error deriving key pair")
+	}
+
+	p, q := primes[0], primes[1]
+	pMinus1 := new(big.Int).Sub(p, one)
+	qMinus1 := new(big.Int).Sub(q, one)
+	lambda := new(big.Int).Mul(pMinus1, qMinus1)
+	lambda.Div(lambda, new(big.Int).GCD(nil, nil, pMinus1, qMinus1))
+
+	priv := &rsa.PrivateKey{
+		PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: rsaKEMExponent},
+		D:         new(big.Int).ModInverse(e, lambda),
+		Primes:    []*big.Int{p, q},
+	}
+	if err := priv.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("Error deriving key pair: %v", err)
+	}
+	priv.Precompute()
+
+	sk := &rsaPrivateKey{s.kemID, priv}
+	return sk, sk.PublicKey(), nil
+}
+
+// nextRSAPrime returns the first prime p from the candidate upwards with
+// gcd(p-1, e) = 1, after setting the top two bits of the candidate so that
+// the product of two such primes has the full size. It gives up after a
+// number of steps that is far beyond the typical gap between primes.
+func nextRSAPrime(candidate []byte, e *big.Int) (*big.Int, bool) {
+	candidate[0] |= 0xC0
+	candidate[len(candidate)-1] |= 1
+	p := new(big.Int).SetBytes(candidate)
+
+	one, two := big.NewInt(1), big.NewInt(2)
+	pMinus1, gcd := new(big.Int), new(big.Int)
+	for i := 0; i < 1<<16; i++ {
+		if p.ProbablyPrime(20) {
+			pMinus1.Sub(p, one)
+			if gcd.GCD(nil, nil, pMinus1, e).Cmp(one) == 0 {
+				return p, true
+			}
+		}
+		p.Add(p, two)
+	}
+	return nil, false
+}
+
+// Marshal and MarshalPrivate fail with ErrKeyMismatch for keys of other KEMs.
+func (s rsaKEMScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*rsaPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, pk)
+	}
+	return raw.Bytes(), nil
+}
+
+func (s rsaKEMScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*rsaPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, sk)
+	}
+	return raw.Bytes(), nil
+}
+
+// Unmarshal accepts SubjectPublicKeyInfo and PKCS #1 encodings of RSA keys
+// whose modulus has exactly the size of the KEM.
+func (s rsaKEMScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	var pub *rsa.PublicKey
+	if key, err := x509.ParsePKIXPublicKey(enc); err == nil {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T is not an RSA key", ErrInvalidKEMPublicKey, key)
+		}
+		pub = rsaKey
+	} else if key, err := x509.ParsePKCS1PublicKey(enc); err == nil {
+		pub = key
+	} else {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+	}
+
+	if pub.N.BitLen() != s.bits {
+		return nil, fmt.Errorf("%w: Invalid modulus size %d != %d", ErrInvalidKEMPublicKey, pub.N.BitLen(), s.bits)
+	}
+	if pub.E < 3 || pub.E%2 == 0 {
+		return nil, fmt.Errorf("%w: Invalid public exponent %d", ErrInvalidKEMPublicKey, pub.E)
+	}
+
+	return &rsaPublicKey{s.kemID, pub}, nil
+}
+
+// UnmarshalPrivate accepts PKCS #1 and PKCS #8 encodings of two-prime RSA
+// keys whose modulus has exactly the size of the KEM.
+func (s rsaKEMScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	var priv *rsa.PrivateKey
+	if key, err := x509.ParsePKCS1PrivateKey(enc); err == nil {
+		priv = key
+	} else if key, err := x509.ParsePKCS8PrivateKey(enc); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T is not an RSA key", ErrInvalidKEMPrivateKey, key)
+		}
+		priv = rsaKey
+	} else {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPrivateKey, err)
+	}
+
+	if priv.N.BitLen() != s.bits {
+		return nil, fmt.Errorf("%w: Invalid modulus size %d != %d", ErrInvalidKEMPrivateKey, priv.N.BitLen(), s.bits)
+	}
+	if len(priv.Primes) != 2 {
+		return nil, fmt.Errorf("%w: Multi-prime keys are not supported", ErrInvalidKEMPrivateKey)
+	}
+	priv.Precompute()
+
+	return &rsaPrivateKey{s.kemID, priv}, nil
+}
+
+func (s rsaKEMScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	ikmE := make([]byte, s.EncapSeedSize())
+	if _, err := io.ReadFull(rand, ikmE); err != nil {
+		return nil, nil, err
+	}
+
+	return s.EncapDeterministic(ikmE, pkR)
+}
+
+// EncapDeterministic samples z from candidates expanded from ikmE, rejecting
+// those that are not less than n.
+func (s rsaKEMScheme) EncapDeterministic(ikmE []byte, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*rsaPublicKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, nil, keyMismatch(s.kemID, pkR)
+	}
+
+	if len(ikmE) != s.EncapSeedSize() {
+		return nil, nil, fmt.Errorf("Invalid encapsulation seed size: got %d, expected %d", len(ikmE), s.EncapSeedSize())
+	}
+
+	N, err := bigmod.NewModulus(raw.pub.N.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	suiteID := kemSuiteID(s.ID())
+	prk := s.KDF.LabeledExtract(nil, suiteID, "encap_prk", ikmE)
+	for counter := 0; counter < 256; counter++ {
+		candidate, err := s.KDF.LabeledExpand(prk, suiteID, "candidate", []byte{byte(counter)}, N.Size())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		z, err := bigmod.NewNat().SetBytes(candidate, N)
+		if err != nil {
+			continue
+		}
+
+		enc := bigmod.NewNat().ExpShortVarTime(z, uint(raw.pub.E), N).Bytes(N)
+		return s.sharedSecret(z.Bytes(N)), enc, nil
+	}
+
+	return nil, nil, fmt.Errorf("Error sampling RSA-KEM secret")
+}
+
+// EncapSeedSize returns the size of the seed from which z is expanded.
+func (s rsaKEMScheme) EncapSeedSize() int {
+	return 32
+}
+
+// Decap decrypts enc with the CRT and the constant-time arithmetic of bigmod,
+// as crypto/rsa does, and checks the result against enc.
+func (s rsaKEMScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw, ok := skR.(*rsaPrivateKey)
+	if !ok || raw.kemID != s.kemID {
+		return nil, keyMismatch(s.kemID, skR)
+	}
+
+	priv := raw.priv
+	N, err := bigmod.NewModulus(priv.N.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if len(enc) != N.Size() {
+		return nil, fmt.Errorf("%w: Invalid ciphertext size %d != %d", ErrInvalidKEMPublicKey, len(enc), N.Size())
+	}
+
+	c, err := bigmod.NewNat().SetBytes(enc, N)
+	if err != nil {
+		return nil, fmt.Errorf("%w: Ciphertext out of range", ErrInvalidKEMPublicKey)
+	}
+
+	P, err := bigmod.NewModulus(priv.Primes[0].Bytes())
+	if err != nil {
+		return nil, err
+	}
+	Q, err := bigmod.NewModulus(priv.Primes[1].Bytes())
+	if err != nil {
+		return nil, err
+	}
+	qInv, err := bigmod.NewNat().SetBytes(priv.Precomputed.Qinv.Bytes(), P)
+	if err != nil {
+		return nil, err
+	}
+
+	// z = m2 + q * (qInv * (m1 - m2) mod p), with m1 = c^dP mod p and
+	// m2 = c^dQ mod q
+	t := bigmod.NewNat()
+	z := bigmod.NewNat().Exp(t.Mod(c, P), priv.Precomputed.Dp.Bytes(), P)
+	m2 := bigmod.NewNat().Exp(t.Mod(c, Q), priv.Precomputed.Dq.Bytes(), Q)
+	z.Sub(t.Mod(m2, P), P)
+	z.Mul(qInv, P)
+	z.ExpandFor(N).Mul(t.Mod(Q.Nat(), N), N)
+	z.Add(m2.ExpandFor(N), N)
+
+	if bigmod.NewNat().ExpShortVarTime(z, uint(priv.E), N).Equal(c) != 1 {
+		return nil, fmt.Errorf("Error decrypting RSA-KEM ciphertext")
+	}
+
+	return s.sharedSecret(z.Bytes(N)), nil
+}
+
+// sharedSecret is the KDF3 of ANS X9.44 with empty OtherInfo, as used by
+// RFC 9690: block i of the output is Hash(I2OSP(i, 4) || Z), counting from 1.
+// Neither enc nor the public key are inputs, since they are determined by Z.
+func (s rsaKEMScheme) sharedSecret(z []byte) []byte {
+	Nsecret := s.KDF.OutputSize()
+	out := make([]byte, 0, Nsecret)
+	for counter := uint32(1); len(out) < Nsecret; counter++ {
+		block := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(z)), counter)
+		out = append(out, s.KDF.Hash(append(block, z...))...)
+	}
+	return out[:Nsecret]
+}
+
+// PublicKeySize returns the size of a SubjectPublicKeyInfo with the public
+// exponent 65537, which is that of almost all RSA keys in use.
+func (s rsaKEMScheme) PublicKeySize() int {
+	pkcs1 := derSize(derIntegerSize(s.bits) + derIntegerSize(17))
+	algorithm := derSize(derSize(9) + derSize(0))
+	return derSize(algorithm + derSize(1+pkcs1))
+}
+
+// PrivateKeySize returns the largest size of a two-prime PKCS #1 private key
+// with the public exponent 65537. Smaller private exponents and CRT values
+// give shorter encodings.
+func (s rsaKEMScheme) PrivateKeySize() int {
+	return derSize(derIntegerSize(1) + 2*derIntegerSize(s.bits) + derIntegerSize(17) + 5*derIntegerSize(s.bits/2))
+}
+
+// derSize returns the size of a DER element with n bytes of content.
+func derSize(n int) int {
+	switch {
+	case n < 0x80:
+		return 2 + n
+	case n < 0x100:
+		return 3 + n
+	default:
+		return 4 + n
+	}
+}
+
+// derIntegerSize returns the size of a DER INTEGER of at most the given
+// number of bits, including the leading zero of a value with its top bit set.
+func derIntegerSize(bits int) int {
+	return derSize(bits/8 + 1)
+}
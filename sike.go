@@ -0,0 +1,244 @@
+//go:build hpke_sike
+
+// SIKE has been broken since 2022 and is withdrawn from the default build. It
+// is only compiled in with the hpke_sike build tag, so that data sealed under
+// the SIKE ciphersuites can still be opened and re-encrypted to a secure
+// ciphersuite with ResealBase or ResealPSK.
+
+package hpke
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/subtle"
+	"fmt"
+	"io"
+
+	"github.com/cloudflare/circl/dh/sidh"
+)
+
+func init() {
+	kems[KEM_SIKE503] = &sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}}
+	kems[KEM_SIKE751] = &sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}}
+
+	withdrawnKEMSchemes[KEM_SIKE503] = func() KEMScheme {
+		return &sikeScheme{field: sidh.Fp503, KDF: hkdfScheme{hash: crypto.SHA512}}
+	}
+	withdrawnKEMSchemes[KEM_SIKE751] = func() KEMScheme {
+		return &sikeScheme{field: sidh.Fp751, KDF: hkdfScheme{hash: crypto.SHA512}}
+	}
+}
+
+///////
+// SIKE
+
+type sikePublicKey struct {
+	field uint8
+	pub   *sidh.PublicKey
+}
+
+func (pub sikePublicKey) KEMID() KEMID {
+	return sikeScheme{field: pub.field}.ID()
+}
+
+func (pub sikePublicKey) Bytes() []byte {
+	out := make([]byte, pub.pub.Size())
+	pub.pub.Export(out)
+	return out
+}
+
+func (pub sikePublicKey) Equal(other KEMPublicKey) bool {
+	o, ok := other.(*sikePublicKey)
+	return ok && pub.field == o.field && bytes.Equal(pub.Bytes(), o.Bytes())
+}
+
+type sikePrivateKey struct {
+	field uint8
+	priv  *sidh.PrivateKey
+	pub   *sidh.PublicKey
+}
+
+func (priv sikePrivateKey) KEMID() KEMID {
+	return sikeScheme{field: priv.field}.ID()
+}
+
+func (priv sikePrivateKey) Bytes() []byte {
+	out := make([]byte, priv.priv.Size())
+	priv.priv.Export(out)
+	return out
+}
+
+func (priv sikePrivateKey) Equal(other KEMPrivateKey) bool {
+	o, ok := other.(*sikePrivateKey)
+	return ok && priv.field == o.field && subtle.ConstantTimeCompare(priv.Bytes(), o.Bytes()) == 1
+}
+
+func (priv sikePrivateKey) Public() KEMPublicKey {
+	return priv.PublicKey()
+}
+
+func (priv sikePrivateKey) PublicKey() KEMPublicKey {
+	return &sikePublicKey{priv.field, priv.pub}
+}
+
+type sikeScheme struct {
+	field uint8
+	KDF   KDFScheme
+}
+
+func (s sikeScheme) ID() KEMID {
+	switch s.field {
+	case sidh.Fp503:
+		return KEM_SIKE503
+	case sidh.Fp751:
+		return KEM_SIKE751
+	}
+	return 0
+}
+
+func (s sikeScheme) GenerateKeyPair(rand io.Reader) (KEMPrivateKey, KEMPublicKey, error) {
+	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
+	err := rawPriv.Generate(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	rawPriv.GeneratePublicKey(rawPub)
+
+	priv := &sikePrivateKey{s.field, rawPriv, rawPub}
+	return priv, priv.PublicKey(), nil
+}
+
+// DeriveKeyPair seeds SIKE key generation with the output of the KDF, since
+// SIKE does not define a derivation of its own.
+func (s sikeScheme) DeriveKeyPair(ikm []byte) (KEMPrivateKey, KEMPublicKey, error) {
+	suiteID := kemSuiteID(s.ID())
+	dkpPRK := s.KDF.LabeledExtract(nil, suiteID, "dkp_prk", ikm)
+	seed, err := s.KDF.LabeledExpand(dkpPRK, suiteID, "sk", nil, s.PrivateKeySize())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return s.GenerateKeyPair(bytes.NewReader(seed))
+}
+
+func (s sikeScheme) Marshal(pk KEMPublicKey) ([]byte, error) {
+	raw, ok := pk.(*sikePublicKey)
+	if !ok || raw.field != s.field {
+		return nil, keyMismatch(s.ID(), pk)
+	}
+	return raw.Bytes(), nil
+}
+
+func (s sikeScheme) MarshalPrivate(sk KEMPrivateKey) ([]byte, error) {
+	raw, ok := sk.(*sikePrivateKey)
+	if !ok || raw.field != s.field {
+		return nil, keyMismatch(s.ID(), sk)
+	}
+	return raw.Bytes(), nil
+}
+
+func (s sikeScheme) Unmarshal(enc []byte) (KEMPublicKey, error) {
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	if len(enc) != rawPub.Size() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPublicKey, len(enc), rawPub.Size())
+	}
+
+	err := rawPub.Import(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPublicKey, err)
+	}
+
+	return &sikePublicKey{s.field, rawPub}, nil
+}
+
+func (s sikeScheme) UnmarshalPrivate(enc []byte) (KEMPrivateKey, error) {
+	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
+	if len(enc) != rawPriv.Size() {
+		return nil, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidKEMPrivateKey, len(enc), rawPriv.Size())
+	}
+
+	err := rawPriv.Import(enc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKEMPrivateKey, err)
+	}
+
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	rawPriv.GeneratePublicKey(rawPub)
+
+	return &sikePrivateKey{s.field, rawPriv, rawPub}, nil
+}
+
+func (s sikeScheme) newKEM(rand io.Reader) (*sidh.KEM, error) {
+	switch s.field {
+	case sidh.Fp503:
+		return sidh.NewSike503(rand), nil
+	case sidh.Fp751:
+		return sidh.NewSike751(rand), nil
+	}
+	return nil, fmt.Errorf("Invalid field")
+}
+
+func (s sikeScheme) Encap(rand io.Reader, pkR KEMPublicKey) ([]byte, []byte, error) {
+	raw, ok := pkR.(*sikePublicKey)
+	if !ok || raw.field != s.field {
+		return nil, nil, keyMismatch(s.ID(), pkR)
+	}
+
+	kem, err := s.newKEM(rand)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc := make([]byte, kem.CiphertextSize())
+	zz := make([]byte, s.KDF.OutputSize())
+	err = kem.Encapsulate(enc, zz, raw.pub)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return zz, enc, nil
+}
+
+// noRandReader stands in for the random source of decapsulation, which must
+// not need any randomness.
+type noRandReader struct{}
+
+func (r noRandReader) Read(unused []byte) (int, error) {
+	return 0, fmt.Errorf("Decapsulation should not read randomness")
+}
+
+func (s sikeScheme) Decap(enc []byte, skR KEMPrivateKey) ([]byte, error) {
+	raw, ok := skR.(*sikePrivateKey)
+	if !ok || raw.field != s.field {
+		return nil, keyMismatch(s.ID(), skR)
+	}
+
+	kem, err := s.newKEM(noRandReader{})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(enc) != kem.CiphertextSize() {
+		return nil, fmt.Errorf("%w: got %d bytes of ciphertext, expected %d", ErrInvalidKEMPublicKey, len(enc), kem.CiphertextSize())
+	}
+
+	zz := make([]byte, s.KDF.OutputSize())
+	err = kem.Decapsulate(zz, raw.priv, raw.pub, enc)
+	if err != nil {
+		return nil, err
+	}
+	//shared secret key
+	return zz, nil
+}
+
+func (s sikeScheme) PublicKeySize() int {
+	rawPub := sidh.NewPublicKey(s.field, sidh.KeyVariantSike)
+	return rawPub.Size()
+}
+
+func (s sikeScheme) PrivateKeySize() int {
+	rawPriv := sidh.NewPrivateKey(s.field, sidh.KeyVariantSike)
+	return rawPriv.Size()
+}
@@ -0,0 +1,64 @@
+//go:build hpke_sike
+
+package hpke
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestSIKEPrivateKeySerialization(t *testing.T) {
+	for _, kemID := range []KEMID{KEM_SIKE503, KEM_SIKE751} {
+		s := kems[kemID]
+
+		skR, pkR, err := s.GenerateKeyPair(rand.Reader)
+		if err != nil {
+			t.Fatalf("[%04x] Error generating KEM key pair: %v", kemID, err)
+		}
+
+		skR2, err := s.UnmarshalPrivate(mustMarshalPrivate(t, s, skR))
+		if err != nil {
+			t.Fatalf("[%04x] Error unmarshaling private key: %v", kemID, err)
+		}
+
+		if !bytes.Equal(mustMarshal(t, s, skR2.PublicKey()), mustMarshal(t, s, pkR)) {
+			t.Fatalf("[%04x] Unmarshaled private key has a different public key", kemID)
+		}
+
+		ss, enc, err := s.Encap(rand.Reader, pkR)
+		if err != nil {
+			t.Fatalf("[%04x] Error in KEM encapsulation: %v", kemID, err)
+		}
+
+		ss2, err := s.Decap(enc, skR2)
+		if err != nil {
+			t.Fatalf("[%04x] Error in KEM decapsulation: %v", kemID, err)
+		}
+
+		if !bytes.Equal(ss, ss2) {
+			t.Fatalf("[%04x] Asymmetric KEM results [%x] != [%x]", kemID, ss, ss2)
+		}
+
+		if _, err := s.Decap(enc[1:], skR2); !errors.Is(err, ErrInvalidKEMPublicKey) {
+			t.Fatalf("[%04x] Truncated ciphertext not reported as invalid: %v", kemID, err)
+		}
+	}
+}
+
+func TestResealSIKE(t *testing.T) {
+	for _, kemID := range []KEMID{KEM_SIKE503, KEM_SIKE751} {
+		oldSuite, err := AssembleCipherSuite(kemID, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", kemID, err)
+		}
+
+		newSuite, err := AssembleCipherSuite(DHKEM_X25519, KDF_HKDF_SHA256, AEAD_AESGCM128)
+		if err != nil {
+			t.Fatalf("[%04x] Error looking up ciphersuite: %v", DHKEM_X25519, err)
+		}
+
+		verifyReseal(t, oldSuite, newSuite)
+	}
+}
@@ -0,0 +1,271 @@
+package hpke
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math/big"
+
+	"filippo.io/bigmod"
+)
+
+//////////////////
+// Short Weierstrass curves
+
+// weierstrassCurve is a curve y^2 = x^3 + a*x + b of prime order n over the
+// field of integers modulo p, for the groups that crypto/ecdh does not
+// implement. Unlike the generic arithmetic of crypto/elliptic, it runs in
+// constant time with respect to the scalar: field elements are bigmod.Nat
+// values, points are added with the complete projective formulas of Renes,
+// Costello and Batina (ePrint 2015/1060, Algorithm 1), which have no special
+// cases for doubling or for the point at infinity, and scalar multiplication
+// walks every 4-bit window of the fixed-size scalar, reading its precomputed
+// multiple with masked selects rather than with a secret index.
+type weierstrassCurve struct {
+	name    string
+	p, n    *bigmod.Modulus
+	a, b    *bigmod.Nat
+	b3      *bigmod.Nat
+	gx, gy  *bigmod.Nat
+	pMinus2 []byte
+}
+
+// weierstrassPoint is a point in projective coordinates (X:Y:Z), which stands
+// for the affine point (X/Z, Y/Z). The point at infinity is (0:1:0).
+type weierstrassPoint struct {
+	x, y, z *bigmod.Nat
+}
+
+// newWeierstrassCurve builds a curve from its hexadecimal domain parameters.
+func newWeierstrassCurve(name string, p, a, b, gx, gy, n string) *weierstrassCurve {
+	c := &weierstrassCurve{name: name}
+
+	var err error
+	if c.p, err = bigmod.NewModulus(hexInt(p).Bytes()); err != nil {
+		panic(err)
+	}
+	if c.n, err = bigmod.NewModulus(hexInt(n).Bytes()); err != nil {
+		panic(err)
+	}
+
+	c.a = c.element(a)
+	c.b = c.element(b)
+	c.b3 = c.add(c.add(c.b, c.b), c.b)
+	c.gx = c.element(gx)
+	c.gy = c.element(gy)
+
+	pMinus2 := hexInt(p)
+	c.pMinus2 = pMinus2.Sub(pMinus2, big.NewInt(2)).Bytes()
+	return c
+}
+
+// element decodes a hexadecimal field element of the domain parameters.
+func (c *weierstrassCurve) element(s string) *bigmod.Nat {
+	x, err := bigmod.NewNat().SetBytes(hexInt(s).Bytes(), c.p)
+	if err != nil {
+		panic(err)
+	}
+	return x
+}
+
+// coordinateSize returns the size of an encoded field element.
+func (c *weierstrassCurve) coordinateSize() int {
+	return c.p.Size()
+}
+
+// scalarSize returns the size of an encoded scalar.
+func (c *weierstrassCurve) scalarSize() int {
+	return c.n.Size()
+}
+
+// The field operations return new values, so that operands may be shared.
+
+func (c *weierstrassCurve) clone(x *bigmod.Nat) *bigmod.Nat {
+	return natSet(bigmod.NewNat().ExpandFor(c.p), x)
+}
+
+func (c *weierstrassCurve) add(x, y *bigmod.Nat) *bigmod.Nat {
+	return c.clone(x).Add(y, c.p)
+}
+
+func (c *weierstrassCurve) sub(x, y *bigmod.Nat) *bigmod.Nat {
+	return c.clone(x).Sub(y, c.p)
+}
+
+func (c *weierstrassCurve) mul(x, y *bigmod.Nat) *bigmod.Nat {
+	return c.clone(x).Mul(y, c.p)
+}
+
+// natSet sets x to y, which must have the same size, and returns x.
+func natSet(x, y *bigmod.Nat) *bigmod.Nat {
+	copy(x.Bits(), y.Bits())
+	return x
+}
+
+func (c *weierstrassCurve) identity() weierstrassPoint {
+	zero := bigmod.NewNat().ExpandFor(c.p)
+	one := bigmod.NewNat().SetUint(1).ExpandFor(c.p)
+	return weierstrassPoint{x: zero, y: one, z: c.clone(zero)}
+}
+
+// addPoints returns p1 + p2 for any two points, including equal points and
+// the point at infinity. It follows the steps of Algorithm 1 in place, except
+// that steps 30 to 32 compute t4 - a*(t2-t0) rather than t4 + a*(t0-t2).
+func (c *weierstrassCurve) addPoints(p1, p2 weierstrassPoint) weierstrassPoint {
+	p := c.p
+	t0 := c.mul(p1.x, p2.x)
+	t1 := c.mul(p1.y, p2.y)
+	t2 := c.mul(p1.z, p2.z)
+	t3 := c.add(p1.x, p1.y)
+	t4 := c.add(p2.x, p2.y)
+	t3.Mul(t4, p)
+	natSet(t4, t0).Add(t1, p)
+	t3.Sub(t4, p)
+	natSet(t4, p1.x).Add(p1.z, p)
+	t5 := c.add(p2.x, p2.z)
+	t4.Mul(t5, p)
+	natSet(t5, t0).Add(t2, p)
+	t4.Sub(t5, p)
+	natSet(t5, p1.y).Add(p1.z, p)
+	x3 := c.add(p2.y, p2.z)
+	t5.Mul(x3, p)
+	natSet(x3, t1).Add(t2, p)
+	t5.Sub(x3, p)
+	z3 := c.mul(c.a, t4)
+	natSet(x3, c.b3).Mul(t2, p)
+	z3.Add(x3, p)
+	natSet(x3, t1).Sub(z3, p)
+	z3.Add(t1, p)
+	y3 := c.mul(x3, z3)
+	natSet(t1, t0).Add(t0, p)
+	t1.Add(t0, p)
+	t2.Mul(c.a, p)
+	t4.Mul(c.b3, p)
+	t1.Add(t2, p)
+	t2.Sub(t0, p)
+	t2.Mul(c.a, p)
+	t4.Sub(t2, p)
+	natSet(t0, t1).Mul(t4, p)
+	y3.Add(t0, p)
+	natSet(t0, t5).Mul(t4, p)
+	x3.Mul(t3, p)
+	x3.Sub(t0, p)
+	natSet(t0, t3).Mul(t1, p)
+	z3.Mul(t5, p)
+	z3.Add(t0, p)
+
+	return weierstrassPoint{x: x3, y: y3, z: z3}
+}
+
+// selectPoint sets q to p if on is 1 and leaves it unchanged if on is 0,
+// without branching on on.
+func selectPoint(q, p weierstrassPoint, on int) {
+	mask := -uint(on)
+	for _, pair := range [][2]*bigmod.Nat{{q.x, p.x}, {q.y, p.y}, {q.z, p.z}} {
+		dst, src := pair[0].Bits(), pair[1].Bits()
+		for i := range dst {
+			dst[i] ^= mask & (dst[i] ^ src[i])
+		}
+	}
+}
+
+// scalarMult returns k*p, where k is a big-endian scalar of scalarSize bytes.
+func (c *weierstrassCurve) scalarMult(k []byte, p weierstrassPoint) weierstrassPoint {
+	var table [16]weierstrassPoint
+	table[0] = c.identity()
+	table[1] = p
+	for i := 2; i < len(table); i++ {
+		table[i] = c.addPoints(table[i-1], p)
+	}
+
+	q := c.identity()
+	for _, b := range k {
+		for _, w := range []byte{b >> 4, b & 0x0F} {
+			for i := 0; i < 4; i++ {
+				q = c.addPoints(q, q)
+			}
+
+			t := c.identity()
+			for i := range table {
+				selectPoint(t, table[i], subtle.ConstantTimeByteEq(byte(i), w))
+			}
+			q = c.addPoints(q, t)
+		}
+	}
+	return q
+}
+
+// checkScalar returns an error unless k encodes a scalar in [1, n-1].
+func (c *weierstrassCurve) checkScalar(k []byte) error {
+	if len(k) != c.scalarSize() {
+		return errors.New("Invalid scalar size")
+	}
+	d, err := bigmod.NewNat().SetBytes(k, c.n)
+	if err != nil || d.IsZero() == 1 {
+		return errors.New("Scalar out of range")
+	}
+	return nil
+}
+
+// decodePoint accepts only uncompressed SEC1 points that are on the curve.
+// The point at infinity has no such encoding.
+func (c *weierstrassCurve) decodePoint(enc []byte) (weierstrassPoint, error) {
+	size := c.coordinateSize()
+	if len(enc) != 1+2*size || enc[0] != 0x04 {
+		return weierstrassPoint{}, errors.New("Invalid point encoding")
+	}
+
+	x, err := bigmod.NewNat().SetBytes(enc[1:1+size], c.p)
+	if err != nil {
+		return weierstrassPoint{}, errors.New("Invalid point coordinate")
+	}
+	y, err := bigmod.NewNat().SetBytes(enc[1+size:], c.p)
+	if err != nil {
+		return weierstrassPoint{}, errors.New("Invalid point coordinate")
+	}
+
+	rhs := c.add(c.mul(c.add(c.mul(x, x), c.a), x), c.b)
+	if c.mul(y, y).Equal(rhs) != 1 {
+		return weierstrassPoint{}, errors.New("Point is not on the curve")
+	}
+
+	return weierstrassPoint{x: x, y: y, z: bigmod.NewNat().SetUint(1).ExpandFor(c.p)}, nil
+}
+
+// encodePoint returns the uncompressed SEC1 encoding of p, inverting Z with
+// a constant-time exponentiation by p-2.
+func (c *weierstrassCurve) encodePoint(p weierstrassPoint) ([]byte, error) {
+	if p.z.IsZero() == 1 {
+		return nil, errors.New("Point at infinity")
+	}
+
+	zInv := bigmod.NewNat().Exp(p.z, c.pMinus2, c.p)
+	enc := make([]byte, 0, 1+2*c.coordinateSize())
+	enc = append(enc, 0x04)
+	enc = append(enc, c.mul(p.x, zInv).Bytes(c.p)...)
+	enc = append(enc, c.mul(p.y, zInv).Bytes(c.p)...)
+	return enc, nil
+}
+
+// ScalarBaseMult returns the encoding of k*G after checking that k is in
+// [1, n-1].
+func (c *weierstrassCurve) ScalarBaseMult(k []byte) ([]byte, error) {
+	if err := c.checkScalar(k); err != nil {
+		return nil, err
+	}
+	g := weierstrassPoint{x: c.gx, y: c.gy, z: bigmod.NewNat().SetUint(1).ExpandFor(c.p)}
+	return c.encodePoint(c.scalarMult(k, g))
+}
+
+// ScalarMult returns the encoding of k*P after checking that k is in [1, n-1]
+// and that P is on the curve. Since n is prime, the result is never the point
+// at infinity.
+func (c *weierstrassCurve) ScalarMult(k, point []byte) ([]byte, error) {
+	if err := c.checkScalar(k); err != nil {
+		return nil, err
+	}
+	p, err := c.decodePoint(point)
+	if err != nil {
+		return nil, err
+	}
+	return c.encodePoint(c.scalarMult(k, p))
+}